@@ -22,8 +22,77 @@ import (
 	"flag"
 	"fmt"
 	"github.com/Francesco149/sharenix/sharenixlib"
+	"os"
+	"strings"
 )
 
+// stringList is a flag that can be repeated, each value is appended
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// importSXCU adds a ShareX custom uploader to the config file
+func importSXCU(path string, setdefault bool) (err error) {
+	sitecfg, defaults, unsupported, err :=
+		sharenixlib.ImportSXCUFile(path)
+	if err != nil {
+		return
+	}
+
+	for _, field := range unsupported {
+		fmt.Println("Warning: unsupported:", field)
+	}
+
+	if !setdefault {
+		defaults = nil
+	}
+
+	cfgPath := sharenixlib.FindConfig()
+	err = sharenixlib.AddService(cfgPath, sitecfg, defaults)
+	if err != nil {
+		return
+	}
+
+	fmt.Println("Added", sitecfg.Name, "to", cfgPath)
+	for _, setting := range defaults {
+		fmt.Println(setting, "is now", sitecfg.Name)
+	}
+	return
+}
+
+// exportSXCU prints a site as a ShareX custom uploader
+func exportSXCU(cfg *sharenixlib.Config, site string) (err error) {
+	sitecfg := cfg.GetServiceByName(site)
+	if sitecfg == nil {
+		return fmt.Errorf("Site not found: %s", site)
+	}
+
+	sx, unsupported, err := cfg.ExportSXCU(sitecfg)
+	if err != nil {
+		return
+	}
+
+	// warnings go to stderr so the output can be redirected to a file
+	for _, field := range unsupported {
+		fmt.Fprintln(os.Stderr, "Warning: unsupported:", field)
+	}
+
+	data, err := sharenixlib.EncodeJSON(sx, "  ")
+	if err != nil {
+		return
+	}
+
+	fmt.Println(string(data))
+	return
+}
+
 func handleCLI() (err error) {
 	cfg, err := sharenixlib.LoadConfig()
 	if err != nil {
@@ -34,6 +103,8 @@ func handleCLI() (err error) {
 	pmode := flag.String("m", "f",
 		"Upload mode - f/file: upload file, fs/fullscreen: screenshot entire "+
 			"screen and upload, s/section: select screen region and upload, "+
+			"w/window: screenshot the active window and upload, "+
+			"pw/pickwindow: click a window, screenshot it and upload, "+
 			"c/clipboard: upload clipboard contents, r/record: record screen "+
 			"region and upload, u/url: shorten url")
 
@@ -61,6 +132,60 @@ func handleCLI() (err error) {
 	pupload := flag.Bool("upload", true, "If false, the file will be "+
 		"archived but not uploaded")
 
+	pframe := flag.Bool("frame", cfg.WindowFrame, "Include the window "+
+		"manager decorations when capturing a window")
+
+	phead := flag.String("head", cfg.CaptureHead, "Full-screen mode only "+
+		"captures this monitor - N: monitor index, pointer: monitor under "+
+		"the mouse pointer, all: every monitor, default: the XineramaHead "+
+		"monitor, which is also used without -head")
+
+	pgeometry := flag.String("geometry", cfg.CaptureGeometry, "Full-screen "+
+		"mode only captures this rectangle, in WxH+X+Y format")
+
+	pcursor := flag.Bool("cursor", cfg.CaptureCursor, "Include the mouse "+
+		"pointer in screenshots")
+
+	pdelay := flag.Float64("delay", cfg.CaptureDelay, "Seconds to wait "+
+		"before taking a screenshot")
+
+	pinterval := flag.Float64("interval", cfg.CaptureInterval, "Seconds "+
+		"between screenshots when -count is greater than 1")
+
+	pcount := flag.Int("count", cfg.CaptureCount, "Number of screenshots "+
+		"to take")
+
+	pbundle := flag.Bool("bundle", cfg.BundleCaptures, "Upload multiple "+
+		"screenshots as a single zip file instead of one by one")
+
+	pformat := flag.String("format", cfg.ImageFormat, "Image format for "+
+		"screenshots, clipboard images and processed image files - png, "+
+		"jpeg or gif")
+
+	pstrip := flag.Bool("strip", cfg.StripMetadata, "Remove EXIF, GPS "+
+		"and other metadata from jpeg, png and gif files before uploading "+
+		"them. The archived copy keeps the metadata")
+
+	// regions from the config are kept, the flag adds more
+	redact := stringList(cfg.Redact)
+	flag.Var(&redact, "redact", "Area to hide in screenshots in x,y,w,h "+
+		"format, relative to the screenshot. Can be repeated")
+
+	predactselect := flag.Bool("redactselect", cfg.RedactSelect, "Drag "+
+		"boxes over the areas to hide before taking a screenshot")
+
+	predactstyle := flag.String("redactstyle", cfg.RedactStyle, "How "+
+		"redacted areas are hidden - pixelate or black")
+
+	pimport := flag.String("import", "", "Add the ShareX custom uploader "+
+		"(.sxcu file) at this path to the config")
+
+	pexport := flag.String("export", "", "Print this site as a ShareX "+
+		"custom uploader (.sxcu file)")
+
+	psetdefault := flag.Bool("setdefault", false, "With -import, make the "+
+		"imported site the default for its destination types")
+
 	flag.Parse()
 	if !flag.Parsed() {
 		panic(errors.New("Unexpected flag error"))
@@ -89,8 +214,12 @@ func handleCLI() (err error) {
 				return
 			}
 
-			fmt.Println("*", record[3], "- URL:", record[0], "Thumbnail URL:",
-				record[1], "Deletion URL:", record[2])
+			if len(record) > 4 && record[4] != "" {
+				fmt.Println("*", record[3], "- Error:", record[4])
+			} else {
+				fmt.Println("*", record[3], "- URL:", record[0],
+					"Thumbnail URL:", record[1], "Deletion URL:", record[2])
+			}
 			fmt.Println()
 		}
 
@@ -99,6 +228,28 @@ func handleCLI() (err error) {
 
 	sharenixlib.ShareNixDebug = *pdebug
 
+	if *pimport != "" {
+		return importSXCU(*pimport, *psetdefault)
+	}
+
+	if *pexport != "" {
+		return exportSXCU(cfg, *pexport)
+	}
+
+	cfg.WindowFrame = *pframe
+	cfg.CaptureHead = *phead
+	cfg.CaptureGeometry = *pgeometry
+	cfg.CaptureCursor = *pcursor
+	cfg.CaptureDelay = *pdelay
+	cfg.CaptureInterval = *pinterval
+	cfg.CaptureCount = *pcount
+	cfg.BundleCaptures = *pbundle
+	cfg.ImageFormat = *pformat
+	cfg.StripMetadata = *pstrip
+	cfg.Redact = redact
+	cfg.RedactSelect = *predactselect
+	cfg.RedactStyle = *predactstyle
+
 	// perform upload
 	_, _, _, err = sharenixlib.ShareNix(
 		cfg, *pmode, *psite, *psilent, *pnotification, *popen, *pclip,
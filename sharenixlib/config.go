@@ -16,10 +16,14 @@
 package sharenixlib
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"path"
 	"os"
+	"regexp"
+	"time"
 )
 
 // A SiteConfig holds the json ShareX config for a single site
@@ -37,6 +41,107 @@ type SiteConfig struct {
 	DeletionURL  string   `json:",omitempty"`
 	Username     string   `json:",omitempty"`
 	Password     string   `json:",omitempty"`
+	// ThumbnailFormName is the form field the local thumbnail is sent as.
+	// no thumbnail is sent if it's empty
+	ThumbnailFormName string `json:",omitempty"`
+	// Timeout is how many seconds each attempt to upload can take. 0 means
+	// no limit
+	Timeout float64 `json:",omitempty"`
+	// Retries is how many more times a failed upload is attempted
+	Retries int `json:",omitempty"`
+	// RetryOn lists the failures that are retried: status codes (503),
+	// classes of status codes (5xx), network and timeout
+	RetryOn []string `json:",omitempty"`
+	// Body is how the request body is built: MultipartFormData,
+	// FormURLEncoded, JSON, XML, Binary or None. if it's empty, the body
+	// depends on RequestType
+	Body string `json:",omitempty"`
+	// Data is the template of JSON and XML request bodies
+	Data string `json:",omitempty"`
+	// Parameters are added to the query string of RequestURL whatever the
+	// Body is. they are escaped after their keywords are replaced
+	Parameters map[string]string `json:",omitempty"`
+	// SuccessStatus lists the status codes (201) and classes of status
+	// codes (2xx) of successful uploads. the default is 2xx
+	SuccessStatus []string `json:",omitempty"`
+	// SuccessCondition is a template that must not evaluate to an empty
+	// string, false, 0 or null for an upload to be successful. it can also
+	// compare two templates with == or !=
+	SuccessCondition string `json:",omitempty"`
+	// ErrorMessage is the template of the error message of failed uploads
+	ErrorMessage string `json:",omitempty"`
+	// PluginProtocol is how PLUGIN sites talk to their plugin: legacy (the
+	// default) or json
+	PluginProtocol string `json:",omitempty"`
+
+	// path of the thumbnail generated for the current upload
+	localThumbnail string
+	// RegexList, compiled the first time it's needed. copies of the site
+	// share it
+	regexps []*regexp.Regexp
+}
+
+// Clone returns a copy of the site config that doesn't share any maps or
+// slices with the original
+func (sitecfg *SiteConfig) Clone() *SiteConfig {
+	res := *sitecfg
+
+	if sitecfg.Headers != nil {
+		res.Headers = make(map[string]string, len(sitecfg.Headers))
+		for k, v := range sitecfg.Headers {
+			res.Headers[k] = v
+		}
+	}
+
+	if sitecfg.Arguments != nil {
+		res.Arguments = make(map[string]string, len(sitecfg.Arguments))
+		for k, v := range sitecfg.Arguments {
+			res.Arguments[k] = v
+		}
+	}
+
+	if sitecfg.Parameters != nil {
+		res.Parameters = make(map[string]string, len(sitecfg.Parameters))
+		for k, v := range sitecfg.Parameters {
+			res.Parameters[k] = v
+		}
+	}
+
+	res.RegexList = append([]string(nil), sitecfg.RegexList...)
+	res.RetryOn = append([]string(nil), sitecfg.RetryOn...)
+	res.SuccessStatus = append([]string(nil), sitecfg.SuccessStatus...)
+	return &res
+}
+
+// CompileRegexList compiles the regular expressions of RegexList. They are
+// only compiled once, later calls return the same expressions
+func (sitecfg *SiteConfig) CompileRegexList() (res []*regexp.Regexp,
+	err error) {
+
+	if sitecfg.regexps != nil || len(sitecfg.RegexList) == 0 {
+		return sitecfg.regexps, nil
+	}
+
+	res = make([]*regexp.Regexp, len(sitecfg.RegexList))
+	for i, expr := range sitecfg.RegexList {
+		res[i], err = regexp.Compile(expr)
+		if err != nil {
+			err = fmt.Errorf("%s: RegexList[%d]: %v", sitecfg.Name, i, err)
+			return
+		}
+	}
+
+	sitecfg.regexps = res
+	return
+}
+
+// RetryPolicy returns the timeout and retry settings of the site
+func (sitecfg *SiteConfig) RetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		Timeout: time.Duration(sitecfg.Timeout * float64(time.Second)),
+		Retries: sitecfg.Retries,
+		RetryOn: sitecfg.RetryOn,
+	}
 }
 
 // A Config holds the json ShareX config for all sites plus the default upload
@@ -45,14 +150,42 @@ type Config struct {
 	DefaultFileUploader  string
 	DefaultImageUploader string
 	DefaultUrlShortener  string
-	XineramaHead         uint32  `json:",omitempty"`
-	NotificationTime     float64 `json:",omitempty"`
-	NotifyUploading      bool    `json:",omitempty"`
-	NotifyCommand        string  `json:",omitempty"`
-	ClipboardTime        float64 `json:",omitempty"`
-	SaveFolder           string  `json:",omitempty"`
-	OrganizedFolders     bool    `json:",omitempty"`
+	XineramaHead         uint32      `json:",omitempty"`
+	NotificationTime     float64     `json:",omitempty"`
+	NotifyUploading      bool        `json:",omitempty"`
+	NotifyCommand        string      `json:",omitempty"`
+	ClipboardTime        float64     `json:",omitempty"`
+	SaveFolder           string      `json:",omitempty"`
+	OrganizedFolders     bool        `json:",omitempty"`
+	WindowFrame          bool        `json:",omitempty"`
+	CaptureHead          string      `json:",omitempty"`
+	CaptureGeometry      string      `json:",omitempty"`
+	CaptureCursor        bool        `json:",omitempty"`
+	CaptureDelay         float64     `json:",omitempty"`
+	CaptureInterval      float64     `json:",omitempty"`
+	CaptureCount         int         `json:",omitempty"`
+	BundleCaptures       bool        `json:",omitempty"`
+	ImageFormat          string      `json:",omitempty"`
+	JPEGQuality          int         `json:",omitempty"`
+	PNGCompression       string      `json:",omitempty"`
+	JPEGAutoThreshold    float64     `json:",omitempty"`
+	ImageTasks           []ImageTask `json:",omitempty"`
+	StripMetadata        bool        `json:",omitempty"`
+	Redact               []string    `json:",omitempty"`
+	RedactSelect         bool        `json:",omitempty"`
+	RedactStyle          string      `json:",omitempty"`
+	RedactPixelSize      int         `json:",omitempty"`
+	Thumbnails           bool        `json:",omitempty"`
+	ThumbnailSize        int         `json:",omitempty"`
+	RecordFPS            float64     `json:",omitempty"`
+	RecordMaxDuration    float64     `json:",omitempty"`
+	RecordFormat         string      `json:",omitempty"`
+	FFmpegCommand        string      `json:",omitempty"`
 	Services             []SiteConfig
+
+	// Progress is called as uploads are sent. It is not part of the json
+	// config and is meant for programs that use sharenixlib
+	Progress ProgressFunc `json:"-"`
 }
 
 // GetServiceByName finds a site config by site name and returns it
@@ -73,6 +206,8 @@ func (cfg *Config) GetServiceByName(name string) *SiteConfig {
 		f/file: upload file
 		fs/fullscreen: screenshot entire screen and upload
 		s/section: select screen region and upload
+		w/window: screenshot the active window and upload
+		pw/pickwindow: click a window, screenshot it and upload
 		c/clipboard: upload clipboard contents
 		r/record: record screen region and upload
 		u/url: shorten url
@@ -87,14 +222,12 @@ func (cfg *Config) Parse(mode string, site string, silent bool) (
 		case "f", "file", "c", "clipboard":
 			site = cfg.DefaultFileUploader
 
-		case "fs", "fullscreen":
+		case "fs", "fullscreen", "s", "section", "w", "window",
+			"pw", "pickwindow", "r", "record":
 			site = cfg.DefaultImageUploader
 
 		case "u", "url":
 			site = cfg.DefaultUrlShortener
-
-		case "s", "section":
-			err = &NotImplementedError{}
 		}
 	}
 
@@ -166,43 +299,208 @@ func (cfg *Config) HandleFileType(currentsitecfg *SiteConfig,
 	return
 }
 
+// fileUploaderFor returns the default file uploader if sitecfg is the
+// default image uploader, otherwise sitecfg. It's used for captures that
+// aren't images, such as videos and zip bundles.
+func (cfg *Config) fileUploaderFor(sitecfg *SiteConfig) (
+	res *SiteConfig, err error) {
+
+	if sitecfg.Name != cfg.DefaultImageUploader ||
+		cfg.DefaultFileUploader == cfg.DefaultImageUploader {
+		return sitecfg, nil
+	}
+
+	DebugPrintln("Switching to default file uploader")
+	res = cfg.GetServiceByName(cfg.DefaultFileUploader)
+	if res == nil {
+		err = &SiteNotFoundError{cfg.DefaultFileUploader}
+	}
+	return
+}
+
 func LoadConfig() (cfg *Config, err error) {
 	cfg = &Config{}
 	cfg.NotificationTime = 30
 	cfg.ClipboardTime = 5
-
-	exeFolder, err := GetExeDir()
+	cfg.RecordFPS = 10
+	cfg.RecordMaxDuration = 60
+	cfg.RecordFormat = "gif"
+	cfg.JPEGQuality = defaultJPEGQuality
+	cfg.StripMetadata = true
+	cfg.RedactStyle = "pixelate"
+	cfg.RedactPixelSize = 12
+	cfg.ThumbnailSize = 256
+
+	file, err := ioutil.ReadFile(FindConfig())
 	if err != nil {
 		return
 	}
 
+	err = json.Unmarshal(file, &cfg)
+	return
+}
+
+// configPaths returns the paths where the config file is looked for, from
+// highest to lowest priority
+func configPaths() []string {
 	cfgName := "sharenix.json"
 
 	cfgPath := os.Getenv("XDG_CONFIG_HOME")
 	if cfgPath == "" {
 		cfgPath = path.Join(GetHome(), ".config")
 	}
-	cfgPaths := [...]string{
+	cfgPaths := []string{
 		path.Join(GetHome(), "."+cfgName),
-		path.Join(exeFolder, cfgName),
+	}
+
+	exeFolder, err := GetExeDir()
+	if err == nil {
+		cfgPaths = append(cfgPaths, path.Join(exeFolder, cfgName))
+	}
+
+	return append(cfgPaths,
 		path.Join("/etc/", cfgName),
 		path.Join("/usr/local/etc/", cfgName),
 		path.Join(cfgPath, "sharenix", cfgName),
+	)
+}
+
+// FindConfig returns the path of the config file that LoadConfig reads. If
+// there is none, the path of the lowest priority location is returned
+func FindConfig() (res string) {
+	for _, res = range configPaths() {
+		if _, err := os.Stat(res); err == nil {
+			return
+		}
+	}
+	return
+}
+
+// EncodeJSON is like json.MarshalIndent with an empty prefix, but doesn't
+// escape <, > and &, which are common in urls
+func EncodeJSON(v interface{}, indent string) (res []byte, err error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", indent)
+	if err = enc.Encode(v); err != nil {
+		return
+	}
+	res = bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	return
+}
+
+// writeOrdered writes a json object with the keys in the given order
+func writeOrdered(keys []string, values map[string]json.RawMessage) (
+	res []byte, err error) {
+
+	buf := bytes.NewBufferString("{\n")
+	for i, key := range keys {
+		var name []byte
+		name, err = EncodeJSON(key, "")
+		if err != nil {
+			return
+		}
+
+		buf.WriteString("  ")
+		buf.Write(name)
+		buf.WriteString(": ")
+		if err = json.Indent(buf, values[key], "  ", "  "); err != nil {
+			return
+		}
+		if i < len(keys)-1 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("\n")
+	}
+	buf.WriteString("}\n")
+	res = buf.Bytes()
+	return
+}
+
+// AddService adds sitecfg to the services in the config file at cfgPath,
+// replacing the site with the same name if there is one. defaults lists
+// the settings, such as DefaultImageUploader, that are set to the new site.
+// The rest of the file is left as it is
+func AddService(cfgPath string, sitecfg *SiteConfig, defaults []string) (
+	err error) {
+
+	file, err := ioutil.ReadFile(cfgPath)
+	if err != nil {
+		return
+	}
+
+	// the top level keys are read in order so the file keeps its layout
+	var keys []string
+	values := make(map[string]json.RawMessage)
+	dec := json.NewDecoder(bytes.NewReader(file))
+	if _, err = dec.Token(); err != nil {
+		return
+	}
+	for dec.More() {
+		var tok json.Token
+		tok, err = dec.Token()
+		if err != nil {
+			return
+		}
+		key, _ := tok.(string)
+
+		var value json.RawMessage
+		if err = dec.Decode(&value); err != nil {
+			return
+		}
+		if _, ok := values[key]; !ok {
+			keys = append(keys, key)
+		}
+		values[key] = value
 	}
 
-	var file []byte
+	var services []json.RawMessage
+	if raw, ok := values["Services"]; ok {
+		if err = json.Unmarshal(raw, &services); err != nil {
+			return
+		}
+	} else {
+		keys = append(keys, "Services")
+	}
 
-	for _, path := range cfgPaths {
-		file, err = ioutil.ReadFile(path)
-		if err == nil {
+	site, err := EncodeJSON(sitecfg, "")
+	if err != nil {
+		return
+	}
+
+	replaced := false
+	for i, raw := range services {
+		var other struct{ Name string }
+		if json.Unmarshal(raw, &other) == nil && other.Name == sitecfg.Name {
+			DebugPrintln("Replacing the existing", sitecfg.Name, "site")
+			services[i] = site
+			replaced = true
 			break
 		}
 	}
+	if !replaced {
+		services = append(services, site)
+	}
+
+	if values["Services"], err = EncodeJSON(services, ""); err != nil {
+		return
+	}
 
+	for _, setting := range defaults {
+		if _, ok := values[setting]; !ok {
+			keys = append([]string{setting}, keys...)
+		}
+		values[setting], err = EncodeJSON(sitecfg.Name, "")
+		if err != nil {
+			return
+		}
+	}
+
+	res, err := writeOrdered(keys, values)
 	if err != nil {
 		return
 	}
 
-	err = json.Unmarshal(file, &cfg)
-	return
+	return ioutil.WriteFile(cfgPath, res, 0644)
 }
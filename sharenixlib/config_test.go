@@ -0,0 +1,53 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"testing"
+)
+
+func TestFileUploaderFor(t *testing.T) {
+	cfg := &Config{
+		DefaultFileUploader:  "files",
+		DefaultImageUploader: "images",
+		Services: []SiteConfig{
+			{Name: "files"}, {Name: "images"}, {Name: "other"},
+		},
+	}
+
+	tests := map[string]string{
+		"images": "files",
+		"files":  "files",
+		"other":  "other",
+	}
+
+	for site, want := range tests {
+		got, err := cfg.fileUploaderFor(cfg.GetServiceByName(site))
+		if err != nil {
+			t.Errorf("%s: %v", site, err)
+			continue
+		}
+		if got.Name != want {
+			t.Errorf("%s: got %s, want %s", site, got.Name, want)
+		}
+	}
+
+	cfg.DefaultFileUploader = "missing"
+	_, err := cfg.fileUploaderFor(cfg.GetServiceByName("images"))
+	if _, ok := err.(*SiteNotFoundError); !ok {
+		t.Errorf("got %v, want a SiteNotFoundError", err)
+	}
+}
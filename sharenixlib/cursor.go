@@ -0,0 +1,129 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"github.com/BurntSushi/xgb"
+	"github.com/BurntSushi/xgb/xfixes"
+	"image"
+	"image/draw"
+)
+
+// CursorImage returns the current mouse pointer image and the position of
+// its top left corner in root window coordinates.
+// Requires the XFixes extension.
+func CursorImage(X *xgb.Conn) (cursor *image.RGBA, pos image.Point,
+	err error) {
+
+	if err = xfixes.Init(X); err != nil {
+		return
+	}
+
+	// the server won't accept xfixes requests until we negotiate a version
+	_, err = xfixes.QueryVersion(X, 4, 0).Reply()
+	if err != nil {
+		return
+	}
+
+	reply, err := xfixes.GetCursorImage(X).Reply()
+	if err != nil {
+		return
+	}
+
+	cursor, pos = cursorFromReply(reply)
+	return
+}
+
+// cursorFromReply converts a GetCursorImage reply to an image and the
+// position of its top left corner, which is the pointer position minus the
+// hotspot
+func cursorFromReply(reply *xfixes.GetCursorImageReply) (
+	cursor *image.RGBA, pos image.Point) {
+
+	w, h := int(reply.Width), int(reply.Height)
+	DebugPrintf("Cursor: %dx%d at %d, %d, hotspot %d, %d\n",
+		w, h, reply.X, reply.Y, reply.Xhot, reply.Yhot)
+
+	// pixels are premultiplied ARGB just like image.RGBA, only packed
+	// differently
+	cursor = image.NewRGBA(image.Rect(0, 0, w, h))
+	for i, argb := range reply.CursorImage {
+		if i >= w*h {
+			break
+		}
+		cursor.Pix[i*4] = uint8(argb >> 16)
+		cursor.Pix[i*4+1] = uint8(argb >> 8)
+		cursor.Pix[i*4+2] = uint8(argb)
+		cursor.Pix[i*4+3] = uint8(argb >> 24)
+	}
+
+	pos = image.Pt(int(reply.X)-int(reply.Xhot), int(reply.Y)-int(reply.Yhot))
+	return
+}
+
+// DrawCursor draws the mouse pointer over pic, which is a capture of the
+// area of the root window that starts at origin
+func DrawCursor(X *xgb.Conn, pic *image.RGBA, origin image.Point) (
+	err error) {
+
+	cursor, pos, err := CursorImage(X)
+	if err != nil {
+		return
+	}
+
+	composeCursor(pic, origin, cursor, pos)
+	return
+}
+
+// composeCursor draws cursor over pic, which is a capture of the area of the
+// root window that starts at origin. pos is the position of the top left
+// corner of the cursor in root window coordinates
+func composeCursor(pic *image.RGBA, origin image.Point, cursor *image.RGBA,
+	pos image.Point) {
+
+	dst := cursor.Bounds().Add(pos.Sub(origin)).Add(pic.Bounds().Min)
+	draw.Draw(pic, dst, cursor, image.ZP, draw.Over)
+}
+
+// DrawScreenCursor draws the mouse pointer over an image returned by
+// CaptureScreen
+func DrawScreenCursor(X *xgb.Conn, pic *image.RGBA) (err error) {
+	rects, err := ScreenRects(X)
+	if err != nil {
+		return
+	}
+
+	head, err := PointerHead(X, rects)
+	if err != nil {
+		return
+	}
+
+	return DrawCursor(X, pic, screenCursorOrigin(rects, head))
+}
+
+// screenCursorOrigin returns the origin to draw the cursor at when it's on
+// rects[head] and the image was built by composeScreens
+func screenCursorOrigin(rects []*ScreenRect, head int) image.Point {
+	var bounds image.Rectangle
+	for _, r := range rects {
+		bounds = bounds.Union(r.Rect)
+	}
+
+	// translate from the root window of the head that has the pointer to
+	// the composite image
+	r := rects[head]
+	return r.RootRect().Min.Sub(r.Rect.Min).Add(bounds.Min)
+}
@@ -0,0 +1,133 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"github.com/BurntSushi/xgb/xfixes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// testCursor is a 3x3 red cursor with a transparent top left corner
+func testCursor() *image.RGBA {
+	cursor := solid(3, 3, red)
+	cursor.SetRGBA(0, 0, color.RGBA{})
+	return cursor
+}
+
+func TestCursorFromReply(t *testing.T) {
+	cursor, pos := cursorFromReply(&xfixes.GetCursorImageReply{
+		X: 10, Y: 20, Width: 2, Height: 2, Xhot: 1, Yhot: 2,
+		CursorImage: []uint32{0xFFFF0000, 0, 0x80008000, 0xFF0000FF},
+	})
+
+	if pos != image.Pt(9, 18) {
+		t.Errorf("the cursor is at %v, want 9, 18", pos)
+	}
+	want := []color.RGBA{red, {}, {0, 0x80, 0, 0x80}, {0, 0, 0xFF, 0xFF}}
+	for i, c := range want {
+		if got := cursor.RGBAAt(i%2, i/2); got != c {
+			t.Errorf("pixel %d is %v, want %v", i, got, c)
+		}
+	}
+}
+
+func TestComposeCursor(t *testing.T) {
+	black := color.RGBA{0, 0, 0, 0xFF}
+	tests := []struct {
+		name   string
+		bounds image.Rectangle
+		origin image.Point
+		pos    image.Point
+		colors map[image.Point]color.RGBA
+	}{
+		{"origin", image.Rect(0, 0, 10, 10), image.ZP, image.Pt(2, 3),
+			map[image.Point]color.RGBA{
+				{2, 3}: black, {3, 3}: red, {4, 5}: red, {5, 6}: black,
+			}},
+		{"capture offset", image.Rect(0, 0, 10, 10), image.Pt(100, 50),
+			image.Pt(104, 52), map[image.Point]color.RGBA{
+				{4, 2}: black, {5, 3}: red, {6, 4}: red,
+			}},
+		{"sub image", image.Rect(5, 5, 15, 15), image.Pt(100, 50),
+			image.Pt(104, 52), map[image.Point]color.RGBA{
+				{9, 7}: black, {10, 8}: red, {11, 9}: red,
+			}},
+		{"clipped top left", image.Rect(0, 0, 10, 10), image.ZP,
+			image.Pt(-1, -1), map[image.Point]color.RGBA{
+				{0, 0}: red, {1, 1}: red, {2, 2}: black,
+			}},
+		{"clipped bottom right", image.Rect(0, 0, 10, 10), image.ZP,
+			image.Pt(8, 8), map[image.Point]color.RGBA{
+				{8, 8}: black, {9, 9}: red, {7, 7}: black,
+			}},
+	}
+
+	for _, test := range tests {
+		pic := image.NewRGBA(test.bounds)
+		for i := 3; i < len(pic.Pix); i += 4 {
+			pic.Pix[i] = 0xFF
+		}
+		composeCursor(pic, test.origin, testCursor(), test.pos)
+		for pt, want := range test.colors {
+			if got := pic.RGBAAt(pt.X, pt.Y); got != want {
+				t.Errorf("%s: %v is %v, want %v", test.name, pt, got, want)
+			}
+		}
+	}
+}
+
+func TestScreenCursorOrigin(t *testing.T) {
+	tests := []struct {
+		name  string
+		heads []*ScreenRect
+		head  int
+		pos   image.Point
+		want  image.Point
+	}{
+		{"xinerama",
+			xineramaHeads(image.Rect(0, 0, 64, 48), image.Rect(64, 0, 96, 24)),
+			1, image.Pt(70, 10), image.Pt(70, 10)},
+		{"xinerama offset",
+			xineramaHeads(image.Rect(10, 5, 74, 53),
+				image.Rect(74, 5, 106, 29)),
+			0, image.Pt(20, 15), image.Pt(10, 10)},
+		{"separate root windows", []*ScreenRect{
+			{image.Rect(0, 0, 64, 48), 0},
+			{image.Rect(64, 0, 96, 32), 1},
+		}, 1, image.Pt(5, 5), image.Pt(69, 5)},
+	}
+
+	cursor := solid(1, 1, red)
+	for _, test := range tests {
+		pic, err := composeFakeScreens(test.heads)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		origin := screenCursorOrigin(test.heads, test.head)
+		composeCursor(pic, origin, cursor, test.pos)
+		if got := pic.RGBAAt(test.want.X, test.want.Y); got != red {
+			t.Errorf("%s: the cursor isn't at %v", test.name, test.want)
+		}
+		next := test.want.Add(image.Pt(1, 0))
+		if got := pic.RGBAAt(next.X, next.Y); got != headColor(test.head) {
+			t.Errorf("%s: %v is %v, want the head color", test.name, next,
+				got)
+		}
+	}
+}
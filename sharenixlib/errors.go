@@ -15,7 +15,10 @@
 
 package sharenixlib
 
-import "fmt"
+import (
+	"fmt"
+	"net/http"
+)
 
 // A NotImplementedError is returned when the called feature is not implemented
 type NotImplementedError struct{}
@@ -33,3 +36,101 @@ type SiteNotFoundError struct {
 func (e *SiteNotFoundError) Error() string {
 	return fmt.Sprintf("Site not found: %s", e.site)
 }
+
+// A SelectionCanceledError is returned when the user cancels a screen
+// region selection
+type SelectionCanceledError struct{}
+
+func (e *SelectionCanceledError) Error() string {
+	return "Selection canceled"
+}
+
+// A RequestError is returned by SendRequest when a request fails, after
+// retrying it as many times as the site allows
+type RequestError struct {
+	Method   string
+	URL      string
+	Attempts int
+	// StatusCode is the status of the last response, or 0 if the request
+	// failed because of a network error
+	StatusCode int
+	// Timeout is true if the last attempt timed out
+	Timeout bool
+	// Err is the network error of the last attempt
+	Err error
+}
+
+func (e *RequestError) Error() string {
+	var reason string
+	switch {
+	case e.Timeout:
+		reason = "timed out"
+	case e.StatusCode != 0:
+		reason = fmt.Sprintf("failed with status %d %s", e.StatusCode,
+			http.StatusText(e.StatusCode))
+	default:
+		reason = fmt.Sprintf("failed: %v", e.Err)
+	}
+
+	attempts := ""
+	if e.Attempts > 1 {
+		attempts = fmt.Sprintf(" (%d attempts)", e.Attempts)
+	}
+	return fmt.Sprintf("%s %s %s%s", e.Method, e.URL, reason, attempts)
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// A ResponseError is returned when the url, thumbnail url or deletion url
+// can't be extracted from the response to an upload
+type ResponseError struct {
+	// Field is URL, ThumbnailURL or DeletionURL
+	Field string
+	// Expression is the keyword that failed, or the whole field if it
+	// can't be parsed
+	Expression string
+	// Response is the part of the response the expression was evaluated
+	// on, if the error comes from it
+	Response string
+	Err      error
+}
+
+// maxResponseErrorLength is how much of the response is shown in errors
+const maxResponseErrorLength = 200
+
+func (e *ResponseError) Error() string {
+	msg := fmt.Sprintf("%s: %s: %v", e.Field, e.Expression, e.Err)
+	if e.Response == "" {
+		return msg
+	}
+
+	response := e.Response
+	if len(response) > maxResponseErrorLength {
+		response = response[:maxResponseErrorLength] + "..."
+	}
+	return fmt.Sprintf("%s, in response %q", msg, response)
+}
+
+func (e *ResponseError) Unwrap() error {
+	return e.Err
+}
+
+// An UploadError is returned when the response to an upload says that it
+// failed
+type UploadError struct {
+	StatusCode int
+	// Message is the error message of the server, or the start of the
+	// response if it's unknown
+	Message string
+}
+
+func (e *UploadError) Error() string {
+	status := fmt.Sprintf("status %d %s", e.StatusCode,
+		http.StatusText(e.StatusCode))
+	if e.Message == "" {
+		return fmt.Sprintf("Upload failed with %s", status)
+	}
+	return fmt.Sprintf("Upload failed (%s): %s", status, e.Message)
+}
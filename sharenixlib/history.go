@@ -36,6 +36,8 @@ func GetUploadHistory() (res [][]string, err error) {
 
 	reader := csv.NewReader(file)
 	reader.Comma = ';'
+	// failed uploads have an extra error message field
+	reader.FieldsPerRecord = -1
 	res, err = reader.ReadAll()
 	return
 }
@@ -50,6 +52,17 @@ func quote(str string) string {
 func AppendToHistory(url, thumbnailurl, deleteurl, filename string) (
 	err error) {
 
+	return appendHistory([]string{url, thumbnailurl, deleteurl, filename})
+}
+
+// AppendErrorToHistory appends a failed upload and its error message to
+// sharenix.csv
+func AppendErrorToHistory(filename, message string) (err error) {
+	return appendHistory([]string{"", "", "", filename, message})
+}
+
+// appendHistory appends a record to sharenix.csv
+func appendHistory(record []string) (err error) {
 	current, err := GetUploadHistory()
 	if err != nil {
 		current = make([][]string, 0)
@@ -57,10 +70,7 @@ func AppendToHistory(url, thumbnailurl, deleteurl, filename string) (
 
 	// TODO: find a more efficient way to append to the file
 
-	//current = append(current, []string{quote(url), quote(thumbnailurl),
-	//	quote(deleteurl), quote(filename)})
-	current = append(current, []string{url, thumbnailurl,
-		deleteurl, filename})
+	current = append(current, record)
 
 	csvPath, err := GetHistoryCSV()
 	if err != nil {
@@ -0,0 +1,128 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+const defaultJPEGQuality = 90
+
+// pngCompression maps the PNGCompression config values to encoder levels
+var pngCompression = map[string]png.CompressionLevel{
+	"":        png.DefaultCompression,
+	"default": png.DefaultCompression,
+	"none":    png.NoCompression,
+	"speed":   png.BestSpeed,
+	"best":    png.BestCompression,
+}
+
+// toRGBA returns img as an *image.RGBA, converting it if necessary
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return rgba
+}
+
+// encodeImage encodes img to the given format, which must be png, jpeg or
+// gif, with the encoder settings in cfg
+func encodeImage(cfg *Config, img image.Image, format string) (
+	data []byte, err error) {
+
+	buf := &bytes.Buffer{}
+
+	switch format {
+	case "png":
+		level, ok := pngCompression[cfg.PNGCompression]
+		if !ok {
+			err = fmt.Errorf("Invalid PNGCompression: %s",
+				cfg.PNGCompression)
+			return
+		}
+		enc := &png.Encoder{CompressionLevel: level}
+		err = enc.Encode(buf, img)
+
+	case "jpeg":
+		// configs that weren't made by LoadConfig have no quality, which
+		// the encoder would clamp to 1
+		quality := cfg.JPEGQuality
+		if quality == 0 {
+			quality = defaultJPEGQuality
+		}
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: quality})
+
+	case "gif":
+		// the standard library quantizer is a fixed palette, median cut
+		// looks a lot better on screenshots
+		rgba := toRGBA(img)
+		err = gif.Encode(buf, Quantize(rgba, rgba.Bounds(), 256), nil)
+
+	default:
+		err = fmt.Errorf("Unsupported image format: %s", format)
+	}
+
+	data = buf.Bytes()
+	return
+}
+
+// EncodeImage encodes an image with the format and settings in the config
+// and returns the encoded data along with the matching file extension.
+// An empty cfg.ImageFormat means png.
+// If cfg.JPEGAutoThreshold is set and a png is larger than that many KB, the
+// image is encoded to jpeg instead.
+func EncodeImage(cfg *Config, img image.Image) (data []byte,
+	extension string, err error) {
+
+	format := cfg.ImageFormat
+	switch format {
+	case "":
+		format = "png"
+	case "jpg":
+		format = "jpeg"
+	}
+
+	data, err = encodeImage(cfg, img, format)
+	if err != nil {
+		return
+	}
+
+	threshold := int(cfg.JPEGAutoThreshold * 1024)
+	if format == "png" && threshold > 0 && len(data) > threshold {
+		DebugPrintln("PNG is", len(data), "bytes, switching to jpeg")
+		format = "jpeg"
+		data, err = encodeImage(cfg, img, format)
+		if err != nil {
+			return
+		}
+	}
+
+	extension = "." + format
+	if format == "jpeg" {
+		extension = ".jpg"
+	}
+
+	DebugPrintln("Encoded", len(data), "bytes of", format)
+	return
+}
@@ -0,0 +1,99 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// gradient returns an image that compresses differently at each jpeg
+// quality
+func gradient(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x * 7), uint8(y * 5),
+				uint8(x * y), 255})
+		}
+	}
+	return img
+}
+
+func TestEncodeImageDefaultJPEGQuality(t *testing.T) {
+	img := gradient(64, 64)
+
+	got, ext, err := EncodeImage(&Config{ImageFormat: "jpeg"}, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ext != ".jpg" {
+		t.Errorf("got extension %s, want .jpg", ext)
+	}
+
+	want, _, err := EncodeImage(&Config{ImageFormat: "jpeg",
+		JPEGQuality: defaultJPEGQuality}, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Errorf("a zero JPEGQuality encoded %d bytes, quality %d encodes %d",
+			len(got), defaultJPEGQuality, len(want))
+	}
+}
+
+func TestEncodeImageFormats(t *testing.T) {
+	img := gradient(64, 64)
+
+	tests := []struct {
+		cfg    Config
+		ext    string
+		format string
+	}{
+		{Config{}, ".png", "png"},
+		{Config{ImageFormat: "jpg"}, ".jpg", "jpeg"},
+		{Config{ImageFormat: "gif"}, ".gif", "gif"},
+		{Config{JPEGAutoThreshold: 1}, ".jpg", "jpeg"},
+		{Config{JPEGAutoThreshold: 1000}, ".png", "png"},
+	}
+
+	for _, test := range tests {
+		data, ext, err := EncodeImage(&test.cfg, img)
+		if err != nil {
+			t.Errorf("%+v: %v", test.cfg, err)
+			continue
+		}
+
+		_, format, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			t.Errorf("%+v: %v", test.cfg, err)
+			continue
+		}
+
+		if ext != test.ext || format != test.format {
+			t.Errorf("%+v: got %s %s, want %s %s", test.cfg, ext, format,
+				test.ext, test.format)
+		}
+	}
+
+	_, _, err := EncodeImage(&Config{ImageFormat: "bmp"}, img)
+	if err == nil {
+		t.Error("encoding to bmp didn't fail")
+	}
+}
@@ -0,0 +1,392 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// An ImageTask is a single step of the image processing pipeline that runs
+// on screenshots and images before they are archived and uploaded.
+// Which fields are used depends on the Type:
+// resize: scales the image down to fit in Width x Height, keeping the aspect
+// ratio. either can be 0 for no limit
+// crop: keeps the Width x Height rectangle at X, Y. a size of 0 extends to
+// the edge of the image
+// border: adds a Size pixels border of the given Color
+// shadow: adds a drop shadow of the given Color, blurred over Size pixels
+// watermark: draws Text (scaled by Size) in the given Color, or the image at
+// the path in Image, at Position with Opacity (0-1)
+// quantize: reduces the image to Colors colors, which makes pngs smaller
+type ImageTask struct {
+	Type     string
+	X        int     `json:",omitempty"`
+	Y        int     `json:",omitempty"`
+	Width    int     `json:",omitempty"`
+	Height   int     `json:",omitempty"`
+	Size     int     `json:",omitempty"`
+	Color    string  `json:",omitempty"`
+	Text     string  `json:",omitempty"`
+	Image    string  `json:",omitempty"`
+	Position string  `json:",omitempty"`
+	Margin   int     `json:",omitempty"`
+	Opacity  float64 `json:",omitempty"`
+	Colors   int     `json:",omitempty"`
+}
+
+// ParseColor parses a color in #RRGGBB or #RRGGBBAA format
+func ParseColor(s string) (c color.NRGBA, err error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) != 6 && len(hex) != 8 {
+		err = fmt.Errorf("Invalid color: %s", s)
+		return
+	}
+
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		err = fmt.Errorf("Invalid color: %s", s)
+		return
+	}
+
+	if len(hex) == 6 {
+		v = v<<8 | 0xFF
+	}
+
+	c = color.NRGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}
+	return
+}
+
+// colorOr parses s or returns def if s is empty
+func colorOr(s string, def color.NRGBA) (color.NRGBA, error) {
+	if s == "" {
+		return def, nil
+	}
+	return ParseColor(s)
+}
+
+// Apply runs the task on img and returns the result. img is not modified.
+func (t *ImageTask) Apply(img *image.RGBA) (res image.Image, err error) {
+	switch t.Type {
+	case "resize":
+		return Resize(img, t.Width, t.Height), nil
+
+	case "crop":
+		size := img.Bounds().Size()
+		w, h := t.Width, t.Height
+		if w <= 0 {
+			w = size.X - t.X
+		}
+		if h <= 0 {
+			h = size.Y - t.Y
+		}
+		return Crop(img, image.Rect(t.X, t.Y, t.X+w, t.Y+h))
+
+	case "border":
+		var c color.NRGBA
+		c, err = colorOr(t.Color, color.NRGBA{0, 0, 0, 0xFF})
+		if err != nil {
+			return
+		}
+		return Border(img, t.Size, c), nil
+
+	case "shadow":
+		var c color.NRGBA
+		c, err = colorOr(t.Color, color.NRGBA{0, 0, 0, 0x80})
+		if err != nil {
+			return
+		}
+		return Shadow(img, t.Size, c), nil
+
+	case "watermark":
+		return t.watermark(img)
+
+	case "quantize":
+		colors := t.Colors
+		if colors <= 0 || colors > 256 {
+			colors = 256
+		}
+		return Quantize(img, img.Bounds(), colors), nil
+	}
+
+	err = fmt.Errorf("Unknown image task: %s", t.Type)
+	return
+}
+
+func (t *ImageTask) watermark(img *image.RGBA) (res image.Image, err error) {
+	var mark image.Image
+	switch {
+	case t.Image != "":
+		var f *os.File
+		f, err = os.Open(t.Image)
+		if err != nil {
+			return
+		}
+		mark, _, err = image.Decode(f)
+		f.Close()
+		if err != nil {
+			return
+		}
+
+	case t.Text != "":
+		var c color.NRGBA
+		c, err = colorOr(t.Color, color.NRGBA{0xFF, 0xFF, 0xFF, 0xFF})
+		if err != nil {
+			return
+		}
+		textmask := DrawText(t.Text, t.Size)
+		text := image.NewRGBA(textmask.Bounds())
+		draw.DrawMask(text, text.Bounds(), image.NewUniform(c), image.ZP,
+			textmask, image.ZP, draw.Over)
+		mark = text
+
+	default:
+		err = fmt.Errorf("watermark needs either Text or Image")
+		return
+	}
+
+	opacity := t.Opacity
+	if opacity <= 0 || opacity > 1 {
+		opacity = 1
+	}
+
+	return Watermark(img, mark, t.Position, t.Margin, opacity)
+}
+
+// ProcessImage runs a list of tasks on img in order
+func ProcessImage(img image.Image, tasks []ImageTask) (
+	res image.Image, err error) {
+
+	res = img
+	for i := range tasks {
+		DebugPrintln("Running image task", tasks[i].Type)
+		res, err = tasks[i].Apply(toRGBA(res))
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Resize scales img down to fit in maxWidth x maxHeight while keeping the
+// aspect ratio. 0 means no limit. Each pixel of the result is the average
+// of the source pixels it covers. Images that already fit are returned as
+// they are.
+func Resize(img *image.RGBA, maxWidth, maxHeight int) *image.RGBA {
+	b := img.Bounds()
+	scale := 1.0
+	if maxWidth > 0 && b.Dx() > maxWidth {
+		scale = float64(maxWidth) / float64(b.Dx())
+	}
+	if maxHeight > 0 && float64(b.Dy())*scale > float64(maxHeight) {
+		scale = float64(maxHeight) / float64(b.Dy())
+	}
+	if scale == 1.0 {
+		return img
+	}
+
+	w := int(float64(b.Dx())*scale + 0.5)
+	h := int(float64(b.Dy())*scale + 0.5)
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	res := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		sy0 := b.Min.Y + y*b.Dy()/h
+		sy1 := b.Min.Y + (y+1)*b.Dy()/h
+		if sy1 == sy0 {
+			sy1++
+		}
+		for x := 0; x < w; x++ {
+			sx0 := b.Min.X + x*b.Dx()/w
+			sx1 := b.Min.X + (x+1)*b.Dx()/w
+			if sx1 == sx0 {
+				sx1++
+			}
+
+			var sum [4]int
+			for sy := sy0; sy < sy1; sy++ {
+				i := img.PixOffset(sx0, sy)
+				for sx := sx0; sx < sx1; sx, i = sx+1, i+4 {
+					for c := 0; c < 4; c++ {
+						sum[c] += int(img.Pix[i+c])
+					}
+				}
+			}
+
+			n := (sx1 - sx0) * (sy1 - sy0)
+			j := res.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				res.Pix[j+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+
+	return res
+}
+
+// Crop returns the part of img inside rect, relative to the top left corner
+// of img
+func Crop(img *image.RGBA, rect image.Rectangle) (*image.RGBA, error) {
+	rect = rect.Add(img.Bounds().Min).Intersect(img.Bounds())
+	if rect.Empty() {
+		return nil, fmt.Errorf("The crop rectangle is outside of the image")
+	}
+
+	res := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(res, res.Bounds(), img, rect.Min, draw.Src)
+	return res, nil
+}
+
+// Border returns img surrounded by a size pixels border of color c
+func Border(img *image.RGBA, size int, c color.Color) *image.RGBA {
+	if size <= 0 {
+		return img
+	}
+
+	b := img.Bounds()
+	res := image.NewRGBA(image.Rect(0, 0, b.Dx()+size*2, b.Dy()+size*2))
+	draw.Draw(res, res.Bounds(), image.NewUniform(c), image.ZP, draw.Src)
+	draw.Draw(res, image.Rect(size, size, size+b.Dx(), size+b.Dy()), img,
+		b.Min, draw.Src)
+	return res
+}
+
+// boxBlur blurs an alpha mask horizontally and vertically with a box of the
+// given radius
+func boxBlur(mask *image.Alpha, radius int) {
+	if radius <= 0 {
+		return
+	}
+
+	b := mask.Bounds()
+	tmp := make([]uint8, len(mask.Pix))
+	n := radius*2 + 1
+
+	// horizontal pass
+	for y := 0; y < b.Dy(); y++ {
+		row := y * mask.Stride
+		for x := 0; x < b.Dx(); x++ {
+			sum := 0
+			for k := x - radius; k <= x+radius; k++ {
+				if k >= 0 && k < b.Dx() {
+					sum += int(mask.Pix[row+k])
+				}
+			}
+			tmp[row+x] = uint8(sum / n)
+		}
+	}
+
+	// vertical pass
+	for x := 0; x < b.Dx(); x++ {
+		for y := 0; y < b.Dy(); y++ {
+			sum := 0
+			for k := y - radius; k <= y+radius; k++ {
+				if k >= 0 && k < b.Dy() {
+					sum += int(tmp[k*mask.Stride+x])
+				}
+			}
+			mask.Pix[y*mask.Stride+x] = uint8(sum / n)
+		}
+	}
+}
+
+// Shadow returns img with a drop shadow of color c that is blurred over size
+// pixels. The image is padded with transparent pixels to fit the shadow.
+func Shadow(img *image.RGBA, size int, c color.Color) *image.RGBA {
+	if size <= 0 {
+		return img
+	}
+
+	b := img.Bounds()
+	offset := size / 2
+	pad := size * 2
+	res := image.NewRGBA(image.Rect(0, 0, b.Dx()+pad*2, b.Dy()+pad*2))
+
+	mask := image.NewAlpha(res.Bounds())
+	shadowRect := image.Rect(pad, pad, pad+b.Dx(), pad+b.Dy()).
+		Add(image.Pt(offset, offset))
+	draw.Draw(mask, shadowRect, image.Opaque, image.ZP, draw.Src)
+	boxBlur(mask, size)
+
+	draw.DrawMask(res, res.Bounds(), image.NewUniform(c), image.ZP, mask,
+		image.ZP, draw.Over)
+	draw.Draw(res, image.Rect(pad, pad, pad+b.Dx(), pad+b.Dy()), img, b.Min,
+		draw.Over)
+	return res
+}
+
+// watermarkPos returns the top left corner of a size big watermark at
+// position (topleft, topright, bottomleft, bottomright or center) inside
+// bounds
+func watermarkPos(bounds image.Rectangle, size image.Point, position string,
+	margin int) (pt image.Point, err error) {
+
+	left := bounds.Min.X + margin
+	right := bounds.Max.X - margin - size.X
+	top := bounds.Min.Y + margin
+	bottom := bounds.Max.Y - margin - size.Y
+
+	switch position {
+	case "topleft":
+		pt = image.Pt(left, top)
+	case "topright":
+		pt = image.Pt(right, top)
+	case "bottomleft":
+		pt = image.Pt(left, bottom)
+	case "", "bottomright":
+		pt = image.Pt(right, bottom)
+	case "center":
+		pt = image.Pt(bounds.Min.X+(bounds.Dx()-size.X)/2,
+			bounds.Min.Y+(bounds.Dy()-size.Y)/2)
+	default:
+		err = fmt.Errorf("Invalid watermark position: %s", position)
+	}
+	return
+}
+
+// Watermark returns a copy of img with mark drawn over it at position
+// (topleft, topright, bottomleft, bottomright or center), margin pixels from
+// the edges, with the given opacity (0-1)
+func Watermark(img *image.RGBA, mark image.Image, position string,
+	margin int, opacity float64) (res *image.RGBA, err error) {
+
+	pt, err := watermarkPos(img.Bounds(), mark.Bounds().Size(), position,
+		margin)
+	if err != nil {
+		return
+	}
+
+	res = image.NewRGBA(img.Bounds())
+	copy(res.Pix, img.Pix)
+	if img.Stride != res.Stride {
+		draw.Draw(res, res.Bounds(), img, img.Bounds().Min, draw.Src)
+	}
+
+	alpha := image.NewUniform(color.Alpha{uint8(opacity * 0xFF)})
+	draw.DrawMask(res, mark.Bounds().Sub(mark.Bounds().Min).Add(pt), mark,
+		mark.Bounds().Min, alpha, image.ZP, draw.Over)
+	return
+}
@@ -0,0 +1,350 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+var (
+	red   = color.RGBA{0xFF, 0, 0, 0xFF}
+	green = color.RGBA{0, 0xFF, 0, 0xFF}
+	white = color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+)
+
+func solid(w, h int, c color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.ZP, draw.Src)
+	return img
+}
+
+func TestParseColor(t *testing.T) {
+	tests := map[string]color.NRGBA{
+		"#FF8000":   {0xFF, 0x80, 0x00, 0xFF},
+		"FF8000":    {0xFF, 0x80, 0x00, 0xFF},
+		"#FF800040": {0xFF, 0x80, 0x00, 0x40},
+	}
+	for s, want := range tests {
+		got, err := ParseColor(s)
+		if err != nil || got != want {
+			t.Errorf("ParseColor(%q) = %v, %v, want %v", s, got, err, want)
+		}
+	}
+
+	for _, s := range []string{"", "#FFF", "#GGGGGG", "#FF80004"} {
+		if _, err := ParseColor(s); err == nil {
+			t.Errorf("ParseColor(%q) didn't fail", s)
+		}
+	}
+}
+
+func TestResize(t *testing.T) {
+	// left half red, right half green
+	img := solid(40, 20, red)
+	draw.Draw(img, image.Rect(20, 0, 40, 20), image.NewUniform(green),
+		image.ZP, draw.Src)
+
+	tests := []struct {
+		w, h int
+		want image.Point
+	}{
+		{20, 0, image.Pt(20, 10)},
+		{0, 5, image.Pt(10, 5)},
+		{10, 10, image.Pt(10, 5)},
+		{100, 100, image.Pt(40, 20)},
+		{0, 0, image.Pt(40, 20)},
+	}
+
+	for _, test := range tests {
+		res := Resize(img, test.w, test.h)
+		if res.Bounds().Size() != test.want {
+			t.Errorf("Resize(%d, %d) is %v, want %v", test.w, test.h,
+				res.Bounds().Size(), test.want)
+			continue
+		}
+
+		last := res.Bounds().Dx() - 1
+		if res.RGBAAt(0, 0) != red || res.RGBAAt(last, 0) != green {
+			t.Errorf("Resize(%d, %d) moved the colors: %v %v", test.w,
+				test.h, res.RGBAAt(0, 0), res.RGBAAt(last, 0))
+		}
+	}
+}
+
+func TestResizeAverages(t *testing.T) {
+	img := solid(2, 1, color.RGBA{0, 0, 0, 0xFF})
+	img.SetRGBA(1, 0, white)
+
+	got := Resize(img, 1, 0).RGBAAt(0, 0)
+	want := color.RGBA{0x7F, 0x7F, 0x7F, 0xFF}
+	if got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCrop(t *testing.T) {
+	img := solid(40, 20, red)
+	img.SetRGBA(12, 7, green)
+
+	res, err := (&ImageTask{Type: "crop", X: 10, Y: 5}).Apply(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Bounds() != image.Rect(0, 0, 30, 15) {
+		t.Errorf("got %v, want 30x15", res.Bounds())
+	}
+	if res.At(2, 2) != green {
+		t.Errorf("the crop starts at the wrong pixel")
+	}
+
+	res, err = Crop(img, image.Rect(30, 10, 100, 100))
+	if err != nil || res.Bounds() != image.Rect(0, 0, 10, 10) {
+		t.Errorf("crop past the edge: %v, %v", res.Bounds(), err)
+	}
+
+	if _, err = Crop(img, image.Rect(50, 50, 60, 60)); err == nil {
+		t.Error("cropping outside of the image didn't fail")
+	}
+}
+
+func TestBorder(t *testing.T) {
+	res, err := (&ImageTask{Type: "border", Size: 3,
+		Color: "#00FF00"}).Apply(solid(10, 10, red))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Bounds() != image.Rect(0, 0, 16, 16) {
+		t.Fatalf("got %v, want 16x16", res.Bounds())
+	}
+	rgba := res.(*image.RGBA)
+	if rgba.RGBAAt(0, 0) != green || rgba.RGBAAt(2, 8) != green ||
+		rgba.RGBAAt(3, 3) != red || rgba.RGBAAt(12, 12) != red {
+		t.Error("the border is in the wrong place")
+	}
+}
+
+func TestShadow(t *testing.T) {
+	res := Shadow(solid(10, 10, red), 4, color.NRGBA{0, 0, 0, 0xFF})
+
+	// 2*size of padding on each side
+	if res.Bounds() != image.Rect(0, 0, 26, 26) {
+		t.Fatalf("got %v, want 26x26", res.Bounds())
+	}
+	if res.RGBAAt(8, 8) != red {
+		t.Errorf("the image moved: %v", res.RGBAAt(8, 8))
+	}
+	if res.RGBAAt(0, 0).A != 0 {
+		t.Errorf("the corner isn't transparent: %v", res.RGBAAt(0, 0))
+	}
+	if a := res.RGBAAt(19, 19).A; a == 0 || a == 0xFF {
+		t.Errorf("the shadow below the image isn't blurred: alpha %d", a)
+	}
+}
+
+func TestWatermark(t *testing.T) {
+	img := solid(20, 20, red)
+	mark := solid(4, 4, green)
+
+	positions := map[string]image.Point{
+		"topleft":     image.Pt(2, 2),
+		"topright":    image.Pt(14, 2),
+		"bottomleft":  image.Pt(2, 14),
+		"bottomright": image.Pt(14, 14),
+		"":            image.Pt(14, 14),
+		"center":      image.Pt(8, 8),
+	}
+
+	for pos, pt := range positions {
+		res, err := Watermark(img, mark, pos, 2, 1)
+		if err != nil {
+			t.Errorf("%s: %v", pos, err)
+			continue
+		}
+		if res.RGBAAt(pt.X, pt.Y) != green ||
+			res.RGBAAt(pt.X+3, pt.Y+3) != green ||
+			res.RGBAAt(pt.X-1, pt.Y) != red {
+			t.Errorf("%s: the watermark isn't at %v", pos, pt)
+		}
+	}
+
+	if img.RGBAAt(2, 2) != red {
+		t.Error("Watermark modified the original image")
+	}
+
+	if _, err := Watermark(img, mark, "middle", 0, 1); err == nil {
+		t.Error("an invalid position didn't fail")
+	}
+
+	res, err := Watermark(img, mark, "topleft", 0, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := res.RGBAAt(0, 0); c.R < 0x70 || c.R > 0x90 || c.G < 0x70 ||
+		c.G > 0x90 {
+		t.Errorf("half opacity blended to %v", c)
+	}
+}
+
+func TestTextWatermark(t *testing.T) {
+	task := &ImageTask{Type: "watermark", Text: "sharenix", Size: 1,
+		Color: "#00FF00", Position: "topleft"}
+	res, err := task.Apply(solid(100, 30, red))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	rgba := res.(*image.RGBA)
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		if rgba.Pix[i+1] == 0xFF {
+			found = true
+			break
+		}
+	}
+	if !found {
+		t.Error("no text was drawn")
+	}
+
+	if _, err = (&ImageTask{Type: "watermark"}).Apply(rgba); err == nil {
+		t.Error("a watermark without text or image didn't fail")
+	}
+}
+
+func TestQuantizeTask(t *testing.T) {
+	img := gradient(32, 32)
+	res, err := (&ImageTask{Type: "quantize", Colors: 16}).Apply(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pal, ok := res.(*image.Paletted)
+	if !ok {
+		t.Fatalf("got a %T, want a paletted image", res)
+	}
+	if len(pal.Palette) > 16 {
+		t.Errorf("got %d colors, want at most 16", len(pal.Palette))
+	}
+	if pal.Bounds() != img.Bounds() {
+		t.Errorf("got %v, want %v", pal.Bounds(), img.Bounds())
+	}
+}
+
+func TestProcessImage(t *testing.T) {
+	// the order matters: the border is added after the resize
+	tasks := []ImageTask{
+		{Type: "resize", Width: 10},
+		{Type: "border", Size: 1},
+	}
+	res, err := ProcessImage(solid(40, 20, red), tasks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Bounds() != image.Rect(0, 0, 12, 7) {
+		t.Errorf("got %v, want 12x7", res.Bounds())
+	}
+
+	_, err = ProcessImage(solid(4, 4, red), []ImageTask{{Type: "sharpen"}})
+	if err == nil {
+		t.Error("an unknown task didn't fail")
+	}
+}
+
+// writeImageFile saves img to dir with the given encoder
+func writeImageFile(t *testing.T, dir, name string,
+	encode func(f *os.File) error) string {
+
+	p := path.Join(dir, name)
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err = encode(f); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestArchiveImageFile(t *testing.T) {
+	withStorage(t)
+	dir := t.TempDir()
+	img := solid(8, 8, red)
+	frame := Quantize(img, img.Bounds(), 16)
+
+	files := map[string]string{
+		"png": writeImageFile(t, dir, "a.png", func(f *os.File) error {
+			return png.Encode(f, img)
+		}),
+		"jpeg": writeImageFile(t, dir, "a.jpg", func(f *os.File) error {
+			return jpeg.Encode(f, img, nil)
+		}),
+		"gif": writeImageFile(t, dir, "a.gif", func(f *os.File) error {
+			return gif.Encode(f, frame, nil)
+		}),
+	}
+	animated := writeImageFile(t, dir, "anim.gif", func(f *os.File) error {
+		return gif.EncodeAll(f, &gif.GIF{
+			Image: []*image.Paletted{frame, frame},
+			Delay: []int{10, 10},
+		})
+	})
+
+	cfg := &Config{ImageTasks: []ImageTask{{Type: "border", Size: 1}}}
+	exts := map[string]string{"png": ".png", "jpeg": ".jpg", "gif": ".gif"}
+
+	for format, file := range files {
+		ok, err := canProcessFile(cfg, file)
+		if err != nil || !ok {
+			t.Errorf("%s: canProcessFile = %v, %v, want true", format, ok,
+				err)
+			continue
+		}
+
+		apath, err := ArchiveImageFile(cfg, file)
+		if err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+		if filepath.Ext(apath) != exts[format] {
+			t.Errorf("%s was archived as %s", format, apath)
+		}
+	}
+
+	cfg.ImageFormat = "png"
+	apath, err := ArchiveImageFile(cfg, files["jpeg"])
+	if err != nil || filepath.Ext(apath) != ".png" {
+		t.Errorf("ImageFormat png: got %s, %v", apath, err)
+	}
+
+	if ok, err := canProcessFile(cfg, animated); err != nil || ok {
+		t.Errorf("animated gif: canProcessFile = %v, %v, want false", ok,
+			err)
+	}
+
+	if ok, err := canProcessFile(&Config{}, files["png"]); err != nil || ok {
+		t.Errorf("no tasks: canProcessFile = %v, %v, want false", ok, err)
+	}
+}
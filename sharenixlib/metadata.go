@@ -0,0 +1,403 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var (
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+	errBadJPEG   = errors.New("Invalid or truncated JPEG")
+	errBadPNG    = errors.New("Invalid or truncated PNG")
+	errBadGIF    = errors.New("Invalid or truncated GIF")
+)
+
+// jpegSegmentName returns a description of a JPEG marker segment if it holds
+// metadata that should be stripped, or an empty string if it must be kept
+func jpegSegmentName(marker byte, payload []byte) string {
+	switch {
+	case marker == 0xE1 && bytes.HasPrefix(payload, []byte("Exif\x00")):
+		return "EXIF"
+	case marker == 0xE1:
+		return "XMP"
+	case marker == 0xE2 && bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00")):
+		// color profiles change how the image looks
+		return ""
+	case marker == 0xED:
+		return "IPTC"
+	case marker == 0xEE:
+		// Adobe segment, tells the decoder which color transform to use
+		return ""
+	case marker >= 0xE2 && marker <= 0xEF:
+		return fmt.Sprintf("APP%d", marker-0xE0)
+	case marker == 0xFE:
+		return "comment"
+	}
+	return ""
+}
+
+// exifOrientation returns the Orientation tag of IFD0 in the payload of an
+// EXIF segment, or 0 if there is none
+func exifOrientation(payload []byte) uint16 {
+	if !bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+		return 0
+	}
+	tiff := payload[6:]
+	if len(tiff) < 8 {
+		return 0
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+
+	n := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < n; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		// a single SHORT is stored in the value field itself
+		if order.Uint16(tiff[entry:]) == 0x0112 &&
+			order.Uint16(tiff[entry+2:]) == 3 {
+			return order.Uint16(tiff[entry+8:])
+		}
+	}
+	return 0
+}
+
+// orientationSegment returns an EXIF segment, including its marker, that
+// only holds the Orientation tag
+func orientationSegment(orientation uint16) []byte {
+	be := binary.BigEndian
+	tiff := make([]byte, 26)
+	copy(tiff, "MM\x00\x2A")
+	be.PutUint32(tiff[4:], 8) // IFD0 offset
+	be.PutUint16(tiff[8:], 1) // number of entries
+	be.PutUint16(tiff[10:], 0x0112)
+	be.PutUint16(tiff[12:], 3) // SHORT
+	be.PutUint32(tiff[14:], 1) // count
+	be.PutUint16(tiff[18:], orientation)
+	// the next IFD offset is 0, there are no more IFDs
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	seg := []byte{0xFF, 0xE1, 0, 0}
+	be.PutUint16(seg[2:], uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+// stripJPEG removes EXIF, XMP, IPTC, comments and other application
+// segments from a JPEG file. JFIF, ICC profiles and Adobe segments are kept.
+// The EXIF Orientation tag is kept as well, otherwise rotated photos would
+// show up sideways
+func stripJPEG(data []byte) (res []byte, removed []string, err error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		err = errBadJPEG
+		return
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(data)))
+	buf.Write(data[:2])
+	i := 2
+
+	for {
+		if i >= len(data) || data[i] != 0xFF {
+			err = errBadJPEG
+			return
+		}
+
+		// markers can be preceded by any number of fill bytes
+		for i < len(data) && data[i] == 0xFF {
+			i++
+		}
+		if i >= len(data) {
+			err = errBadJPEG
+			return
+		}
+		marker := data[i]
+		i++
+
+		switch {
+		case marker == 0xD9:
+			// end of image
+			buf.Write([]byte{0xFF, marker})
+			res = buf.Bytes()
+			return
+
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			// standalone markers with no length
+			buf.Write([]byte{0xFF, marker})
+			continue
+		}
+
+		if i+2 > len(data) {
+			err = errBadJPEG
+			return
+		}
+		length := int(binary.BigEndian.Uint16(data[i:]))
+		if length < 2 || i+length > len(data) {
+			err = errBadJPEG
+			return
+		}
+
+		if marker == 0xDA {
+			// start of scan, the entropy coded data follows. anything after
+			// this point is image data
+			buf.WriteByte(0xFF)
+			buf.Write(data[i-1:])
+			res = buf.Bytes()
+			return
+		}
+
+		payload := data[i+2 : i+length]
+		if name := jpegSegmentName(marker, payload); name != "" {
+			removed = append(removed, name)
+			// 1 is the default, upright orientation
+			if o := exifOrientation(payload); o > 1 {
+				DebugPrintln("Keeping EXIF orientation", o)
+				buf.Write(orientationSegment(o))
+			}
+		} else {
+			buf.Write([]byte{0xFF, marker})
+			buf.Write(data[i : i+length])
+		}
+		i += length
+	}
+}
+
+// stripPNG removes text, EXIF and timestamp chunks from a PNG file
+func stripPNG(data []byte) (res []byte, removed []string, err error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		err = errBadPNG
+		return
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(data)))
+	buf.Write(pngSignature)
+	i := len(pngSignature)
+
+	for {
+		// length, type, data, crc
+		if i+8 > len(data) {
+			err = errBadPNG
+			return
+		}
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			err = errBadPNG
+			return
+		}
+
+		chunkType := string(data[i+4 : i+8])
+		switch chunkType {
+		case "tEXt", "zTXt", "iTXt", "eXIf", "tIME":
+			removed = append(removed, chunkType)
+		default:
+			buf.Write(data[i:end])
+		}
+		i = end
+
+		if chunkType == "IEND" {
+			res = buf.Bytes()
+			return
+		}
+	}
+}
+
+// gifSubBlocks returns the offset right after the chain of data sub-blocks
+// that starts at i
+func gifSubBlocks(data []byte, i int) (int, error) {
+	for {
+		if i >= len(data) {
+			return 0, errBadGIF
+		}
+		size := int(data[i])
+		i += size + 1
+		if size == 0 {
+			return i, nil
+		}
+	}
+}
+
+// stripGIF removes comments and application extensions other than the
+// animation loop settings from a GIF file
+func stripGIF(data []byte) (res []byte, removed []string, err error) {
+	if len(data) < 13 || (!bytes.HasPrefix(data, []byte("GIF87a")) &&
+		!bytes.HasPrefix(data, []byte("GIF89a"))) {
+		err = errBadGIF
+		return
+	}
+
+	// header, logical screen descriptor and global color table
+	i := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		i += 3 << (uint(flags&7) + 1)
+	}
+	if i > len(data) {
+		err = errBadGIF
+		return
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(data)))
+	buf.Write(data[:i])
+
+	for {
+		if i >= len(data) {
+			err = errBadGIF
+			return
+		}
+
+		start := i
+		switch data[i] {
+		case 0x3B:
+			// trailer
+			buf.WriteByte(data[i])
+			res = buf.Bytes()
+			return
+
+		case 0x2C:
+			// image descriptor, optional local color table, lzw code size
+			// and image data
+			if i+10 > len(data) {
+				err = errBadGIF
+				return
+			}
+			i += 10
+			if flags := data[start+9]; flags&0x80 != 0 {
+				i += 3 << (uint(flags&7) + 1)
+			}
+			i++
+			if i, err = gifSubBlocks(data, i); err != nil {
+				return
+			}
+			buf.Write(data[start:i])
+
+		case 0x21:
+			if i+2 > len(data) {
+				err = errBadGIF
+				return
+			}
+			label := data[i+1]
+			if i, err = gifSubBlocks(data, i+2); err != nil {
+				return
+			}
+
+			// the identifier is the first sub-block of an application
+			// extension
+			app := data[start+2:]
+			switch {
+			case label == 0xFE:
+				removed = append(removed, "comment")
+			case label == 0xFF && len(app) > 12 &&
+				!bytes.Equal(app[1:12], []byte("NETSCAPE2.0")) &&
+				!bytes.Equal(app[1:12], []byte("ANIMEXTS1.0")):
+				removed = append(removed, fmt.Sprintf("application %q",
+					string(app[1:12])))
+			default:
+				buf.Write(data[start:i])
+			}
+
+		default:
+			err = errBadGIF
+			return
+		}
+	}
+}
+
+// StripMetadata removes metadata such as EXIF, GPS coordinates, XMP and
+// comments from a JPEG, PNG or GIF file. Image data is left untouched.
+// removed describes each stripped block. Data in other formats is returned
+// as is.
+func StripMetadata(data []byte) (res []byte, removed []string, err error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		return stripJPEG(data)
+	case bytes.HasPrefix(data, pngSignature):
+		return stripPNG(data)
+	case bytes.HasPrefix(data, []byte("GIF8")):
+		return stripGIF(data)
+	}
+	return data, nil, nil
+}
+
+// StripFileMetadata writes a copy of the file at path without metadata to a
+// temporary directory and returns its path. The copy has the same name as
+// the original. If there is nothing to strip, path is returned and tmpdir is
+// empty, otherwise tmpdir must be removed once the copy is no longer needed.
+func StripFileMetadata(path string) (stripped, tmpdir string, err error) {
+	stripped = path
+
+	// other files can be huge and are never read into memory
+	mimeType, err := SniffMimeType(path)
+	if err != nil {
+		return
+	}
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/gif":
+	default:
+		return
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	res, removed, err := StripMetadata(data)
+	if err != nil {
+		err = fmt.Errorf("Failed to strip metadata from %s: %v", path, err)
+		return
+	}
+
+	if len(removed) == 0 {
+		DebugPrintln("No metadata found in", path)
+		return
+	}
+	DebugPrintln("Stripped", len(data)-len(res), "bytes of metadata from",
+		path+":", removed)
+
+	tmpdir, err = ioutil.TempDir("", "sharenix")
+	if err != nil {
+		return
+	}
+
+	stripped = filepath.Join(tmpdir, filepath.Base(path))
+	err = ioutil.WriteFile(stripped, res, 0600)
+	if err != nil {
+		os.RemoveAll(tmpdir)
+		stripped, tmpdir = path, ""
+	}
+	return
+}
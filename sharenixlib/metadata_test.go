@@ -0,0 +1,275 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+// exifPayload builds a little endian EXIF payload with a camera model, a GPS
+// pointer and the given orientation in IFD0
+func exifPayload(orientation uint16) []byte {
+	le := binary.LittleEndian
+	entries := []struct {
+		tag, typ uint16
+		value    uint32
+	}{
+		{0x0110, 2, 0x6E6F6850}, // model "Phon"
+		{0x0112, 3, uint32(orientation)},
+		{0x8825, 4, 0}, // GPS IFD pointer
+	}
+
+	tiff := []byte("II\x2A\x00\x08\x00\x00\x00")
+	tiff = append(tiff, byte(len(entries)), 0)
+	for _, e := range entries {
+		entry := make([]byte, 12)
+		le.PutUint16(entry, e.tag)
+		le.PutUint16(entry[2:], e.typ)
+		le.PutUint32(entry[4:], 4)
+		if e.typ == 3 {
+			le.PutUint32(entry[4:], 1)
+		}
+		le.PutUint32(entry[8:], e.value)
+		tiff = append(tiff, entry...)
+	}
+	tiff = append(tiff, 0, 0, 0, 0)
+	return append([]byte("Exif\x00\x00"), tiff...)
+}
+
+// jpegSegment returns a marker segment with the given payload
+func jpegSegment(marker byte, payload []byte) []byte {
+	seg := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+// testJPEG encodes a small image and inserts the given segments right after
+// the start of image marker
+func testJPEG(t *testing.T, segments ...[]byte) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, solid(8, 8, red), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	res := append([]byte{}, data[:2]...)
+	for _, seg := range segments {
+		res = append(res, seg...)
+	}
+	return append(res, data[2:]...)
+}
+
+// jpegSegments returns the payloads of the segments with the given marker
+// that come before the image data
+func jpegSegments(data []byte, marker byte) (res [][]byte) {
+	for i := 2; i+4 <= len(data) && data[i+1] != 0xDA; {
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if data[i+1] == marker {
+			res = append(res, data[i+4:i+2+length])
+		}
+		i += 2 + length
+	}
+	return
+}
+
+func TestStripJPEG(t *testing.T) {
+	data := testJPEG(t,
+		jpegSegment(0xE1, exifPayload(6)),
+		jpegSegment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00<x/>")),
+		jpegSegment(0xFE, []byte("shot on a phone")),
+	)
+
+	res, removed, err := StripMetadata(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"EXIF", "XMP", "comment"}
+	if !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed %v, want %v", removed, want)
+	}
+	if _, err = jpeg.Decode(bytes.NewReader(res)); err != nil {
+		t.Error("the stripped image doesn't decode:", err)
+	}
+	if bytes.Contains(res, []byte("Phon")) ||
+		bytes.Contains(res, []byte("shot on a phone")) {
+		t.Error("metadata was left in the image")
+	}
+
+	app1 := jpegSegments(res, 0xE1)
+	if len(app1) != 1 {
+		t.Fatalf("got %d APP1 segments, want only the orientation", len(app1))
+	}
+	if o := exifOrientation(app1[0]); o != 6 {
+		t.Errorf("the orientation is %d, want 6", o)
+	}
+	if len(app1[0]) != 32 {
+		t.Errorf("the kept EXIF segment is %d bytes, want 32", len(app1[0]))
+	}
+}
+
+func TestStripJPEGUpright(t *testing.T) {
+	for _, orientation := range []uint16{0, 1} {
+		data := testJPEG(t, jpegSegment(0xE1, exifPayload(orientation)))
+		res, _, err := StripMetadata(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if app1 := jpegSegments(res, 0xE1); len(app1) != 0 {
+			t.Errorf("orientation %d: kept %d EXIF segments, want none",
+				orientation, len(app1))
+		}
+	}
+}
+
+func TestExifOrientation(t *testing.T) {
+	tests := []struct {
+		payload []byte
+		want    uint16
+	}{
+		{exifPayload(8), 8},
+		{jpegSegment(0xE1, nil)[4:], 0},
+		{[]byte("Exif\x00\x00MM"), 0},
+		{[]byte("Exif\x00\x00XX\x00\x2A\x00\x00\x00\x08"), 0},
+		// IFD0 points past the end of the segment
+		{[]byte("Exif\x00\x00MM\x00\x2A\x00\x00\xFF\xFF"), 0},
+		{orientationSegment(3)[4:], 3},
+	}
+
+	for i, test := range tests {
+		if got := exifOrientation(test.payload); got != test.want {
+			t.Errorf("%d: got %d, want %d", i, got, test.want)
+		}
+	}
+}
+
+// pngChunk returns a PNG chunk with its length and crc
+func pngChunk(typ string, data []byte) []byte {
+	chunk := make([]byte, 4, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	chunk = append(chunk, typ...)
+	chunk = append(chunk, data...)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(chunk[4:]))
+	return append(chunk, crc...)
+}
+
+func TestStripPNG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, solid(8, 8, red)); err != nil {
+		t.Fatal(err)
+	}
+	clean := buf.Bytes()
+
+	// right after the IHDR chunk
+	ihdr := len(pngSignature) + 25
+	data := append([]byte{}, clean[:ihdr]...)
+	data = append(data, pngChunk("tEXt", []byte("Author\x00someone"))...)
+	data = append(data, pngChunk("tIME", make([]byte, 7))...)
+	data = append(data, clean[ihdr:]...)
+
+	res, removed, err := StripMetadata(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"tEXt", "tIME"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed %v, want %v", removed, want)
+	}
+	if !bytes.Equal(res, clean) {
+		t.Error("the stripped image differs from the original")
+	}
+}
+
+func TestStripGIF(t *testing.T) {
+	img := solid(8, 8, red)
+	var buf bytes.Buffer
+	err := gif.Encode(&buf, Quantize(img, img.Bounds(), 16), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clean := buf.Bytes()
+
+	comment := append([]byte{0x21, 0xFE, 5}, "hello\x00"...)
+	app := append([]byte{0x21, 0xFF, 11}, "XMP DataXMP\x01\x00\x00"...)
+	trailer := len(clean) - 1
+	data := append([]byte{}, clean[:trailer]...)
+	data = append(data, comment...)
+	data = append(data, app...)
+	data = append(data, clean[trailer:]...)
+
+	res, removed, err := StripMetadata(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"comment", `application "XMP DataXMP"`}
+	if !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed %v, want %v", removed, want)
+	}
+	if !bytes.Equal(res, clean) {
+		t.Error("the stripped image differs from the original")
+	}
+}
+
+func TestStripFileMetadata(t *testing.T) {
+	dir := t.TempDir()
+	jpg := path.Join(dir, "photo.jpg")
+	err := ioutil.WriteFile(jpg, testJPEG(t,
+		jpegSegment(0xFE, []byte("comment"))), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stripped, tmpdir, err := StripFileMetadata(jpg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	if tmpdir == "" || path.Base(stripped) != "photo.jpg" ||
+		path.Dir(stripped) != tmpdir {
+		t.Errorf("got %s in %s, want a copy in a temporary dir", stripped,
+			tmpdir)
+	}
+
+	// files that aren't images are never read, not even if they are named
+	// like one and huge
+	big := path.Join(dir, "big.jpg")
+	f, err := os.Create(big)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteString("PK\x03\x04 not a picture")
+	if err == nil {
+		err = f.Truncate(4 << 30)
+	}
+	f.Close()
+	if err != nil {
+		t.Skip("can't create a sparse file:", err)
+	}
+
+	stripped, tmpdir, err = StripFileMetadata(big)
+	if err != nil || stripped != big || tmpdir != "" {
+		t.Errorf("got %s, %q, %v, want the file untouched", stripped, tmpdir,
+			err)
+	}
+}
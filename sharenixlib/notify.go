@@ -73,6 +73,17 @@ func lockEnd(index int) (err error) {
 	return os.Remove(file)
 }
 
+// SetNotificationMarkup formats and replaces the text of a notification
+// window created by Notifyf. It is safe to call from any goroutine
+func SetNotificationMarkup(win *gtk.Window, format string, a ...interface{}) {
+	text := fmt.Sprintf("ShareNix: "+format, a...)
+	glib.IdleAdd(func() {
+		// the label is the only child of the notification window
+		l := &gtk.Label{Misc: gtk.Misc{Widget: *win.GetChild()}}
+		l.SetMarkup(text)
+	})
+}
+
 // Notifyf formats and shows a notification as a bordeless GTK window in the
 // bottom right corner of the screen.
 // Right-clicking the notification dismisses it and terminates the process.
@@ -132,12 +143,16 @@ func Notifyf(head uint32, expire time.Duration, onInit func(*gtk.Window),
 
 	X.Close()
 
+	// the notification is a window on the head's screen so we need its
+	// position relative to the root window
+	bounds := rects[head].RootRect()
+
 	// our window is now the right width for the notification text
 	// (clamped to a max of 60 chars).
 
 	// calculate notification position so that it doens't overlap other notifs
 	width, height := win.GetSize()
-	y := rects[head].Rect.Max.Y - height - 10
+	y := bounds.Max.Y - height - 10
 
 	for ; ; lockIndex++ {
 		var free bool
@@ -157,12 +172,12 @@ func Notifyf(head uint32, expire time.Duration, onInit func(*gtk.Window),
 		y -= 10
 
 		if y < 0 {
-			y = rects[head].Rect.Max.Y - height - 10
+			y = bounds.Max.Y - height - 10
 		}
 	}
 
 	// Position window in the bottom right corner of the screen
-	win.Move(rects[head].Rect.Max.X-width-10, y)
+	win.Move(bounds.Max.X-width-10, y)
 
 	// ghetto way to fix the positioning bug when resizing a pango.ELLIPSIZE_END
 	// widget by limiting text width first and then restoring full text after
@@ -18,6 +18,7 @@ package sharenixlib
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/ChrisTrenkamp/goxpath"
 	"github.com/ChrisTrenkamp/goxpath/tree/xmltree"
@@ -26,105 +27,124 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
-	"unicode"
+	"time"
 )
 
-// TODO: named regex support
-
-// ParseRegexList parses a list of regular expressions on the given input
-// and returns a slice of slices of strings with the match groups of each regex
-func ParseRegexList(input string, regexList []string) (
-	res [][]string, err error) {
-
-	res = make([][]string, len(regexList))
-
-	for i, regex := range regexList {
-		var re *regexp.Regexp
-		re, err = regexp.Compile(regex)
-		if err != nil {
-			return
-		}
-
-		matches := re.FindAllStringSubmatch(input, -1)
+// A RegexResult holds every match of a regular expression
+type RegexResult struct {
+	// Matches holds the groups of each match, the first group is the whole
+	// match
+	Matches [][]string
+	// Names holds the names of the groups, empty for unnamed groups
+	Names []string
+}
 
-		if len(matches) > 0 {
-			res[i] = matches[0]
-		} else {
-			res[i] = nil
-		}
+// MatchRegex runs re on input and returns all of its matches
+func MatchRegex(re *regexp.Regexp, input string) RegexResult {
+	return RegexResult{
+		Matches: re.FindAllStringSubmatch(input, -1),
+		Names:   re.SubexpNames(),
 	}
-
-	return
 }
 
-// parseRegexSyntax parses a $n$ or $n,n$ substring and returns the regexp match
-// that should replace it
-func parseRegexSyntax(text string, regexResults [][]string) string {
-	// 1:1 port of ShareX's code to achieve the closest similarity in behaviour
+// ParseRegexList runs a list of regular expressions on the given input and
+// returns the matches of each regex
+func ParseRegexList(input string, regexList []*regexp.Regexp) (
+	res []RegexResult) {
 
-	if len(text) == 0 {
-		return ""
+	res = make([]RegexResult, len(regexList))
+	for i, re := range regexList {
+		res[i] = MatchRegex(re, input)
 	}
+	return
+}
 
-	regexIndexString := make([]rune, 0)
-	var regexIndex int
-	isGroupRegex := false
-	i := 0
-
-	textRunes := []rune(text)
-
-	for ; i < len(textRunes); i++ {
-		if unicode.IsDigit(textRunes[i]) {
-			regexIndexString = append(regexIndexString, textRunes[i])
-		} else {
-			if textRunes[i] == rune(',') {
-				isGroupRegex = true
+// Group returns a group of the n-th match, starting from 1. group is either
+// the number or the name of the group, an empty group is the whole match.
+// Matches that don't exist are empty, like in ShareX
+func (r *RegexResult) Group(group string, n int) (res string, err error) {
+	index := 0
+	if group != "" {
+		index = -1
+		for i, name := range r.Names {
+			if name == group {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			index, err = strconv.Atoi(group)
+			if err != nil {
+				err = fmt.Errorf("There is no regex group named %q", group)
+				return
 			}
-
-			break
 		}
 	}
 
-	if len(regexIndexString) == 0 {
-		return ""
+	if index < 0 || index >= len(r.Names) {
+		err = fmt.Errorf("There is no regex group %d", index)
+		return
 	}
 
-	regexIndex, err := strconv.Atoi(string(regexIndexString))
-	if err != nil {
-		return ""
+	if n < 1 || n > len(r.Matches) {
+		return
 	}
 
-	if regexIndex < 1 || regexIndex > len(regexResults) {
-		return ""
-	}
+	res = r.Matches[n-1][index]
+	return
+}
+
+// selectRegexGroup returns the group of a match of the index-th regex
+// results. index and match start from 1, match defaults to the first one
+func selectRegexGroup(results []RegexResult, index, group,
+	match string) (res string, err error) {
 
-	match := regexResults[regexIndex-1]
+	i, err := strconv.Atoi(index)
+	if err != nil || i < 1 || i > len(results) {
+		err = fmt.Errorf("There is no regex %s", index)
+		return
+	}
 
-	if isGroupRegex && i+1 < len(textRunes) {
-		group := textRunes[i+1:]
-		groupNumber, err := strconv.Atoi(string(group))
+	n := 1
+	if match != "" {
+		n, err = strconv.Atoi(match)
 		if err != nil {
-			return ""
+			err = fmt.Errorf("Invalid regex match number %q", match)
+			return
 		}
+	}
 
-		if groupNumber < 0 || groupNumber >= len(match) {
-			return ""
-		}
+	return results[i-1].Group(group, n)
+}
+
+// parseRegexSyntax parses a $n$, $n,group$ or $n,group,match$ substring and
+// returns the regexp match that should replace it
+func parseRegexSyntax(text string, regexResults []RegexResult) (res string,
+	err error) {
 
-		return match[groupNumber]
+	parts := strings.Split(text, ",")
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+	if len(parts) > 3 {
+		err = fmt.Errorf("Invalid regex reference %q", text)
+		return
 	}
 
-	return match[0]
+	return selectRegexGroup(regexResults, strings.TrimSpace(parts[0]),
+		strings.TrimSpace(parts[1]), strings.TrimSpace(parts[2]))
 }
 
-func parseJsonSyntax(syntax string, jsonblob []byte) string {
+// parseJsonSyntax returns the value at the json path syntax in jsonblob
+func parseJsonSyntax(syntax string, jsonblob []byte) (res string, err error) {
 	DebugPrintln("jsonblob:", string(jsonblob))
 	DebugPrintln("syntax:", syntax)
 
 	paths, err := jsonpath.ParsePaths("$." + syntax + "+")
 	if err != nil {
 		DebugPrintln(err)
-		return "(invalid jsonpath)" // TODO: throw errors
+		err = fmt.Errorf("Invalid json path %q", syntax)
+		return
 	}
 
 	DebugPrintln("paths:", paths)
@@ -132,13 +152,19 @@ func parseJsonSyntax(syntax string, jsonblob []byte) string {
 	eval, err := jsonpath.EvalPathsInBytes(jsonblob, paths)
 	if err != nil {
 		DebugPrintln(err)
-		return "(invalid json)"
+		err = fmt.Errorf("Invalid json")
+		return
 	}
 
 	result, ok := eval.Next()
-	if !ok || eval.Error != nil {
+	if eval.Error != nil {
 		DebugPrintln("result is", result, "err is", eval.Error)
-		return "(jsonpath not found)"
+		err = fmt.Errorf("Invalid json: %v", eval.Error)
+		return
+	}
+	if !ok {
+		err = fmt.Errorf("%q not found in the json", syntax)
+		return
 	}
 
 	DebugPrintln("jsonpath result:", result.Pretty(true))
@@ -147,29 +173,42 @@ func parseJsonSyntax(syntax string, jsonblob []byte) string {
 	err = json.Unmarshal(result.Value, &val)
 	if err != nil {
 		DebugPrintln(err)
-		return "(failed to parse json value)"
+		err = fmt.Errorf("Invalid json value at %q", syntax)
+		return
 	}
 
-	return fmt.Sprintf("%v", val)
+	// null would print as <nil>
+	if val == nil {
+		res = "null"
+		return
+	}
+
+	res = fmt.Sprintf("%v", val)
+	return
 }
 
-func parseXmlSyntax(syntax string, xml []byte) string {
+// parseXmlSyntax returns the result of the xpath syntax on xml
+func parseXmlSyntax(syntax string, xml []byte) (res string, err error) {
 	xpExec, err := goxpath.Parse(syntax)
 	if err != nil {
-		return "(invalid xpath)"
+		err = fmt.Errorf("Invalid xpath %q: %v", syntax, err)
+		return
 	}
 
 	xTree, err := xmltree.ParseXML(bytes.NewBuffer(xml))
 	if err != nil {
-		return "(invalid xml)"
+		err = fmt.Errorf("Invalid xml: %v", err)
+		return
 	}
 
-	res, err := xpExec.Exec(xTree)
+	xres, err := xpExec.Exec(xTree)
 	if err != nil {
-		return "(xpath not found)"
+		err = fmt.Errorf("%q not found in the xml: %v", syntax, err)
+		return
 	}
 
-	return res.String()
+	res = xres.String()
+	return
 }
 
 const (
@@ -179,72 +218,39 @@ const (
 	ParseXml
 )
 
-// ParseUrl replaces the following syntaxes in url and returns the
-// modified string.
-// - regex matches: $regex:n,n$, $regex:n$, $n,n$, $n$
-// - json paths: $json:some.json.element$
-// - xml xpaths: $xml:/root/some/xml/element$
-func ParseUrl(response []byte, url string, regexResults [][]string) string {
-	// 1:1 port of ShareX's code to achieve the closest similarity in behaviour
+// ParseUrl replaces the keywords in url with values taken from the response
+// and returns the modified string. See ExpandTemplate for the syntax
+func ParseUrl(response []byte, url string,
+	regexResults []RegexResult) (string, error) {
+
+	return ExpandTemplate(url, &TemplateContext{
+		Time:         time.Now(),
+		Response:     response,
+		RegexResults: regexResults,
+	})
+}
+
+// parseResponseField expands the template of field, one of the url fields
+// of a site. Errors are returned as a *ResponseError
+func parseResponseField(field, template string, ctx *TemplateContext) (
+	res string, err error) {
 
-	if len(url) == 0 {
-		return ""
+	res, err = ExpandTemplate(template, ctx)
+	if err == nil {
+		return
 	}
 
-	urlRunes := []rune(url)
-	resultRunes := make([]rune, 0)
-
-	syntaxStart := false
-	parseType := ParseRegex
-	syntaxStartIndex := 0
-
-	for i := 0; i < len(urlRunes); i++ {
-		if urlRunes[i] == rune('$') {
-			if !syntaxStart {
-				syntaxStart = true
-
-				syntaxCheck := strings.ToLower(string(urlRunes[i+1:]))
-
-				if strings.HasPrefix(syntaxCheck, "regex:") {
-					parseType = ParseRegex
-					syntaxStartIndex = i + 7
-				} else if strings.HasPrefix(syntaxCheck, "json:") {
-					parseType = ParseJson
-					syntaxStartIndex = i + 6
-				} else if strings.HasPrefix(syntaxCheck, "xml:") {
-					parseType = ParseXml
-					syntaxStartIndex = i + 5
-				} else {
-					syntaxStartIndex = i + 1
-				}
-			} else {
-				parseText :=
-					strings.TrimSpace(string(urlRunes[syntaxStartIndex:i]))
-
-				if len(parseText) != 0 {
-					var parseRes string
-
-					switch parseType {
-					default:
-					case ParseRegex:
-						parseRes = parseRegexSyntax(parseText, regexResults)
-					case ParseJson:
-						parseRes = parseJsonSyntax(parseText, response)
-					case ParseXml:
-						parseRes = parseXmlSyntax(parseText, response)
-					}
-
-					resultRunes = append(resultRunes, []rune(parseRes)...)
-				}
-
-				syntaxStart = false
-			}
-		} else if !syntaxStart {
-			resultRunes = append(resultRunes, urlRunes[i])
+	resperr := &ResponseError{Field: field, Expression: template, Err: err}
+	var terr *TemplateError
+	if errors.As(err, &terr) {
+		if terr.Keyword != "" {
+			resperr.Expression = terr.Keyword
 		}
+		resperr.Response = terr.Input
+		resperr.Err = terr.Err
 	}
-
-	return string(resultRunes)
+	err = resperr
+	return
 }
 
 // Parses a uri list returned by "x-special/gnome-copied-files"
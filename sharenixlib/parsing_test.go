@@ -0,0 +1,171 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const regexResponse = "id=abc key=111 id=def key=222"
+
+func testRegexResults() []RegexResult {
+	return ParseRegexList(regexResponse, []*regexp.Regexp{
+		regexp.MustCompile(`id=(?P<id>\w+) key=(?P<key>\d+)`),
+		regexp.MustCompile(`key=(\d)(\d+)`),
+	})
+}
+
+func TestRegexResultGroup(t *testing.T) {
+	results := testRegexResults()
+	tests := []struct {
+		group string
+		n     int
+		want  string
+	}{
+		{"", 1, "id=abc key=111"},
+		{"", 2, "id=def key=222"},
+		{"1", 1, "abc"},
+		{"id", 2, "def"},
+		{"key", 1, "111"},
+		{"2", 2, "222"},
+		// matches that don't exist are empty, like in ShareX
+		{"id", 3, ""},
+		{"id", 0, ""},
+	}
+
+	for _, test := range tests {
+		got, err := results[0].Group(test.group, test.n)
+		if err != nil || got != test.want {
+			t.Errorf("Group(%q, %d) = %q, %v, want %q", test.group, test.n,
+				got, err, test.want)
+		}
+	}
+
+	for _, group := range []string{"name", "3", "-1"} {
+		if _, err := results[0].Group(group, 1); err == nil {
+			t.Errorf("Group(%q, 1) didn't fail", group)
+		}
+	}
+}
+
+func TestParseUrlRegex(t *testing.T) {
+	results := testRegexResults()
+	tests := map[string]string{
+		"$1$":                     "id=abc key=111",
+		"$1,id$":                  "abc",
+		"$regex:1,id,2$":          "def",
+		"$ 1 , key , 2 $":         "222",
+		"$2,2,2$":                 "22",
+		"{regex:1}":               "id=abc key=111",
+		"{regex:1|key}":           "111",
+		"{regex:1|id|2}":          "def",
+		"{regex:2|1|2}":           "2",
+		"a/$1,id$/{regex:1|id|2}": "a/abc/def",
+		// inline regexes run on the response
+		`{regex:key=(?P<k>\d+)|k|2}`: "222",
+	}
+
+	for url, want := range tests {
+		got, err := ParseUrl([]byte(regexResponse), url, results)
+		if err != nil || got != want {
+			t.Errorf("ParseUrl(%q) = %q, %v, want %q", url, got, err, want)
+		}
+	}
+}
+
+func TestCompileRegexList(t *testing.T) {
+	sitecfg := &SiteConfig{Name: "test", RegexList: []string{`a(\d)`, `b`}}
+	res, err := sitecfg.CompileRegexList()
+	if err != nil || len(res) != 2 {
+		t.Fatalf("got %v, %v", res, err)
+	}
+
+	again, err := sitecfg.Clone().CompileRegexList()
+	if err != nil || &again[0] != &res[0] {
+		t.Error("a copy of the site compiled the regexes again")
+	}
+
+	bad := &SiteConfig{Name: "test", RegexList: []string{`b`, `a(`}}
+	if _, err = bad.CompileRegexList(); err == nil {
+		t.Error("an invalid regex didn't fail")
+	}
+}
+
+func TestParseUrlErrors(t *testing.T) {
+	results := testRegexResults()
+	tests := []struct {
+		name, url, response, keyword, msg string
+	}{
+		{"missing json path", "$json:b$", `{"a": 1}`, "$json:b$",
+			"not found"},
+		{"missing json path", "{json:a.b}", `{"a": 1}`, "{json:a.b}",
+			"not found"},
+		{"invalid json", "$json:a$", `{"a": `, "$json:a$", "Invalid json"},
+		{"invalid json path", "{json:a[}", `{"a": 1}`, "{json:a[}",
+			"Invalid json path"},
+		{"invalid xpath", "$xml:/a[$", "<a/>", "$xml:/a[$", "Invalid xpath"},
+		{"invalid xml", "$xml:/a$", "<a>", "$xml:/a$", "Invalid xml"},
+		{"unterminated keyword", "x{json:a", "{}", "", "Unterminated"},
+		{"unknown keyword", "{nope}", "", "{nope}", "Unknown keyword"},
+		{"missing regex", "$3$", "", "$3$", "no regex 3"},
+		{"missing regex group", "$1,name$", "", "$1,name$",
+			`no regex group named "name"`},
+		{"missing regex group", "$regex:1,5$", "", "$regex:1,5$",
+			"no regex group 5"},
+		{"missing regex group", "{regex:1|name|2}", "", "{regex:1|name|2}",
+			`no regex group named "name"`},
+		{"invalid match number", "$1,id,x$", "", "$1,id,x$",
+			"Invalid regex match number"},
+		{"invalid regex reference", "$1,2,3,4$", "", "$1,2,3,4$",
+			"Invalid regex reference"},
+		{"invalid regex", "{regex:a(}", "", "{regex:a(}", "Invalid regex"},
+	}
+
+	for _, test := range tests {
+		res, err := ParseUrl([]byte(test.response), test.url, results)
+		var terr *TemplateError
+		if !errors.As(err, &terr) {
+			t.Errorf("%s: %s gave %q, %v, want a TemplateError", test.name,
+				test.url, res, err)
+			continue
+		}
+		if terr.Keyword != test.keyword ||
+			!strings.Contains(terr.Err.Error(), test.msg) {
+
+			t.Errorf("%s: %s failed in %q with %q, want %q and %q",
+				test.name, test.url, terr.Keyword, terr.Err, test.keyword,
+				test.msg)
+		}
+	}
+}
+
+func TestParseResponseFieldError(t *testing.T) {
+	ctx := &TemplateContext{Response: []byte(`{"error": "quota"}`)}
+	_, err := parseResponseField("URL", "https://x/$json:link$", ctx)
+
+	var resperr *ResponseError
+	if !errors.As(err, &resperr) {
+		t.Fatalf("got %v, want a ResponseError", err)
+	}
+	if resperr.Field != "URL" || resperr.Expression != "$json:link$" ||
+		resperr.Response != `{"error": "quota"}` {
+
+		t.Errorf("got %+v", resperr)
+	}
+}
@@ -114,6 +114,7 @@ func GenerateArchivedFilename(extension string) (string, error) {
 		} else if i >= 1000 {
 			return p, fmt.Errorf("Failed to generate unique filename")
 		}
+		i++
 	}
 
 	return "", fmt.Errorf("This should never happen")
@@ -0,0 +1,39 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"testing"
+)
+
+func TestCreateArchiveFileUnique(t *testing.T) {
+	withStorage(t)
+
+	// more files than can get different timestamps in the same second
+	seen := make(map[string]bool)
+	for i := 0; i < 5; i++ {
+		f, path, err := CreateArchiveFile(".png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		if seen[path] {
+			t.Fatalf("%s was returned twice", path)
+		}
+		seen[path] = true
+	}
+}
@@ -17,11 +17,57 @@ package sharenixlib
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"os/exec"
 	"path"
+	"path/filepath"
+	"sort"
+	"strings"
 )
 
+// plugin protocols, set with the PluginProtocol field of a site
+const (
+	// PluginLegacy passes Arguments as command line flags and reads the
+	// last line of output
+	PluginLegacy = "legacy"
+	// PluginJSON sends a PluginRequest to the plugin's stdin and reads a
+	// PluginResult from its stdout
+	PluginJSON = "json"
+)
+
+// PluginProtocolVersion is the version of the json plugin protocol
+const PluginProtocolVersion = 1
+
+// A PluginRequest is sent to the stdin of json plugins
+type PluginRequest struct {
+	Version int    `json:"version"`
+	Site    string `json:"site"`
+	// File is the path of the file to upload, empty when shortening urls
+	File string `json:"file,omitempty"`
+	Mime string `json:"mime,omitempty"`
+	// Arguments are the Arguments of the site, with keywords replaced
+	Arguments map[string]string `json:"arguments"`
+	// Keywords holds the values of input, filename, extension and
+	// thumbnail
+	Keywords map[string]string `json:"keywords"`
+}
+
+// A PluginResult is read from the stdout of json plugins. Fields that
+// aren't listed here can be read with the json keywords
+type PluginResult struct {
+	Version      int    `json:"version"`
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnail_url"`
+	DeletionURL  string `json:"deletion_url"`
+	// Error is set when the upload failed
+	Error string `json:"error"`
+	// Status and Headers are optional, for plugins that talk to a server
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+}
+
 // RunPlugin starts pluginName in the plugin directory passing command-line
 // params in the following format:
 // 	pluginName -param1Name=param1Value ... -paramXName=paramXValue param_tail
@@ -33,18 +79,26 @@ import (
 // 	})
 // will execute
 // 	foo -hello=world -someflag=true bar
-// Returns the last line outputted to stdout by the plugin and an error if any.
+// Returns a response whose body is the last line outputted to stdout by the
+// plugin and an error if any.
 // Any trailing newlines at the end of the output are stripped.
 func RunPlugin(pluginName string,
-	extraParams map[string]string) (output string, err error) {
+	extraParams map[string]string) (res *Response, err error) {
+
+	var names []string
+	for paramName := range extraParams {
+		if paramName != "_tail" {
+			names = append(names, paramName)
+		}
+	}
+	sort.Strings(names)
 
-	formattedArgs := []string{extraParams["_tail"]}
-	delete(extraParams, "_tail")
-	for paramName, paramValue := range extraParams {
-		formattedArgs = append(
-			[]string{fmt.Sprintf("-%s=%s", paramName, paramValue)},
-			formattedArgs...)
+	var formattedArgs []string
+	for _, paramName := range names {
+		formattedArgs = append(formattedArgs,
+			fmt.Sprintf("-%s=%s", paramName, extraParams[paramName]))
 	}
+	formattedArgs = append(formattedArgs, extraParams["_tail"])
 
 	pluginsDir, err := GetPluginsDir()
 	if err != nil {
@@ -66,6 +120,127 @@ func RunPlugin(pluginName string,
 	} else {
 		DebugPrintln("Plugin output was one line long")
 	}
-	output = string(outdata)
+	res = &Response{StatusCode: http.StatusOK, Body: outdata}
+	return
+}
+
+// RunPluginJSON starts pluginName in the plugin directory, writes req to its
+// stdin as json and reads a PluginResult from its stdout. The response body
+// is the json result, so its fields can be read with json keywords.
+// Plugins that exit with an error status can still print a result with an
+// error, which is reported instead of the exit status.
+// Whatever the plugin writes to stderr is logged in debug mode.
+func RunPluginJSON(pluginName string, req *PluginRequest) (res *Response,
+	err error) {
+
+	pluginsDir, err := GetPluginsDir()
+	if err != nil {
+		return
+	}
+
+	req.Version = PluginProtocolVersion
+	input, err := json.Marshal(req)
+	if err != nil {
+		return
+	}
+	DebugPrintln("Plugin request:", string(input))
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(path.Join(pluginsDir, pluginName))
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+
+	for _, line := range strings.Split(stderr.String(), "\n") {
+		if line != "" {
+			DebugPrintln(pluginName+":", line)
+		}
+	}
+	DebugPrintln("Plugin result:", stdout.String(), "with error", err)
+
+	// a plugin that exits with an error result failed like any upload,
+	// other failures only have the exit status and stderr to explain them
+	var result PluginResult
+	jsonerr := json.Unmarshal(stdout.Bytes(), &result)
+	if err != nil && (jsonerr != nil || result.Error == "") {
+		// the last line of stderr usually says what went wrong
+		msg := strings.TrimSpace(stderr.String())
+		if i := strings.LastIndexByte(msg, '\n'); i >= 0 {
+			msg = msg[i+1:]
+		}
+		if msg != "" {
+			err = fmt.Errorf("Plugin %s failed: %v: %s", pluginName, err, msg)
+		} else {
+			err = fmt.Errorf("Plugin %s failed: %v", pluginName, err)
+		}
+		return
+	}
+	err = nil
+
+	if jsonerr != nil {
+		err = fmt.Errorf("Plugin %s returned invalid json: %v", pluginName,
+			jsonerr)
+		return
+	}
+	if result.Version != 0 && result.Version != PluginProtocolVersion {
+		err = fmt.Errorf("Plugin %s uses protocol version %d, expected %d",
+			pluginName, result.Version, PluginProtocolVersion)
+		return
+	}
+
+	res = &Response{
+		StatusCode: result.Status,
+		Header:     make(http.Header),
+		Body:       stdout.Bytes(),
+		plugin:     &result,
+	}
+	for k, v := range result.Headers {
+		res.Header.Set(k, v)
+	}
+
+	if res.StatusCode == 0 {
+		res.StatusCode = http.StatusOK
+	}
+	// an error always fails the upload
+	if result.Error != "" && res.StatusCode < 300 {
+		res.StatusCode = http.StatusInternalServerError
+	}
+	return
+}
+
+// runPlugin runs the plugin of a PLUGIN site with the protocol it uses.
+// file is the path of the uploaded file, empty when shortening input
+func (sitecfg *SiteConfig) runPlugin(file, input string) (res *Response,
+	err error) {
+
+	switch sitecfg.PluginProtocol {
+	case "", PluginLegacy:
+		return RunPlugin(sitecfg.RequestURL, sitecfg.Arguments)
+
+	case PluginJSON:
+		req := &PluginRequest{
+			Site:      sitecfg.Name,
+			File:      file,
+			Arguments: sitecfg.Arguments,
+			Keywords: map[string]string{
+				"input":     input,
+				"filename":  input,
+				"extension": filepath.Ext(file),
+				"thumbnail": sitecfg.localThumbnail,
+			},
+		}
+		if req.Arguments == nil {
+			req.Arguments = map[string]string{}
+		}
+		if file != "" {
+			if req.Mime, err = SniffMimeType(file); err != nil {
+				return
+			}
+		}
+		return RunPluginJSON(sitecfg.RequestURL, req)
+	}
+
+	err = fmt.Errorf("Unknown PluginProtocol %q", sitecfg.PluginProtocol)
 	return
 }
@@ -0,0 +1,209 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os/exec"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writePlugin installs a shell script as a plugin in the plugin directory
+func writePlugin(t *testing.T, name, script string) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not installed")
+	}
+
+	dir, err := GetPluginsDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path.Join(dir, name), []byte("#!/bin/sh\n"+script),
+		0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunPluginResponse(t *testing.T) {
+	withStorage(t)
+	writePlugin(t, "echo", `echo "uploading $@"
+echo "https://example.com/$2"
+`)
+
+	res, err := RunPlugin("echo", map[string]string{"name": "a.png",
+		"_tail": "tail"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != 200 || string(res.Body) != "https://example.com/tail" {
+		t.Errorf("got %d %q", res.StatusCode, res.Body)
+	}
+
+	// the response goes through the same parsing as http responses
+	sitecfg := &SiteConfig{RegexList: []string{`/(\w+)$`},
+		URL: "https://example.com/v/$1,1$"}
+	url, _, _, err := ParseResponse(sitecfg, res, "a.png")
+	if err != nil || url != "https://example.com/v/tail" {
+		t.Errorf("ParseResponse = %q, %v", url, err)
+	}
+
+	writePlugin(t, "silent", "")
+	if _, err = RunPlugin("silent", nil); err == nil {
+		t.Error("a plugin without output didn't fail")
+	}
+}
+
+func TestRunPluginLegacyFlags(t *testing.T) {
+	withStorage(t)
+	writePlugin(t, "args", `echo "$@"`+"\n")
+
+	sitecfg := &SiteConfig{RequestURL: "args", Arguments: map[string]string{
+		"zeta": "1", "alpha": "2", "mid": "x y", "_tail": "file.png",
+	}}
+	// flags come in the same order every time
+	for i := 0; i < 5; i++ {
+		res, err := sitecfg.runPlugin("file.png", "file.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "-alpha=2 -mid=x y -zeta=1 file.png"
+		if string(res.Body) != want {
+			t.Fatalf("got %q, want %q", res.Body, want)
+		}
+	}
+}
+
+func TestRunPluginJSON(t *testing.T) {
+	withStorage(t)
+	writePlugin(t, "json", `cat > "$(dirname "$0")/request.json"
+echo "uploading" >&2
+echo '{"version": 1, "url": "https://example.com/a",
+	"thumbnail_url": "https://example.com/t", "id": "abc"}'
+`)
+
+	file := path.Join(t.TempDir(), "a.png")
+	err := ioutil.WriteFile(file, []byte("\x89PNG\r\n\x1a\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sitecfg := &SiteConfig{Name: "test", RequestURL: "json",
+		PluginProtocol: PluginJSON, Arguments: map[string]string{"k": "v"},
+		DeletionURL: "https://example.com/delete/$json:id$"}
+	res, err := sitecfg.runPlugin(file, "a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, _ := GetPluginsDir()
+	data, err := ioutil.ReadFile(path.Join(dir, "request.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req PluginRequest
+	if err = json.Unmarshal(data, &req); err != nil {
+		t.Fatalf("the plugin got invalid json %q: %v", data, err)
+	}
+	want := PluginRequest{
+		Version:   PluginProtocolVersion,
+		Site:      "test",
+		File:      file,
+		Mime:      "image/png",
+		Arguments: map[string]string{"k": "v"},
+		Keywords: map[string]string{"input": "a.png", "filename": "a.png",
+			"extension": ".png", "thumbnail": ""},
+	}
+	if !reflect.DeepEqual(req, want) {
+		t.Errorf("the plugin got %+v, want %+v", req, want)
+	}
+
+	// empty url fields fall back to the result, extra fields can be read
+	// with json keywords
+	url, thumburl, deleteurl, err := ParseResponse(sitecfg, res, "a.png")
+	if err != nil || url != "https://example.com/a" ||
+		thumburl != "https://example.com/t" ||
+		deleteurl != "https://example.com/delete/abc" {
+
+		t.Errorf("ParseResponse = %q, %q, %q, %v", url, thumburl,
+			deleteurl, err)
+	}
+}
+
+func TestRunPluginJSONErrors(t *testing.T) {
+	withStorage(t)
+
+	tests := []struct {
+		name, script, msg string
+	}{
+		{"exit status", "echo 'starting' >&2\necho 'no api key' >&2\nexit 3",
+			"no api key"},
+		// a result without an error doesn't hide the exit status
+		{"exit status with a result",
+			`echo '{"url": "https://example.com/a"}'` + "\nexit 2",
+			"exit status 2"},
+		{"exit status with invalid json", "echo 'oops'\nexit 4",
+			"exit status 4"},
+		{"invalid json", "echo 'https://example.com/a'", "invalid json"},
+		{"version", `echo '{"version": 2, "url": "https://example.com/a"}'`,
+			"protocol version 2"},
+	}
+	for _, test := range tests {
+		writePlugin(t, "fail", test.script+"\n")
+		_, err := RunPluginJSON("fail", &PluginRequest{})
+		if err == nil || !strings.Contains(err.Error(), test.msg) {
+			t.Errorf("%s: got %v, want an error with %q", test.name, err,
+				test.msg)
+		}
+	}
+
+	// an error in the result fails the upload with its message
+	writePlugin(t, "fail", `echo '{"error": "quota exceeded"}'`+"\n")
+	sitecfg := &SiteConfig{RequestURL: "fail", PluginProtocol: PluginJSON}
+	res, err := sitecfg.runPlugin("", "https://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, _, err = ParseResponse(sitecfg, res, "https://example.com")
+	var uploaderr *UploadError
+	if !errors.As(err, &uploaderr) || uploaderr.Message != "quota exceeded" {
+		t.Errorf("got %v, want the error of the plugin", err)
+	}
+
+	// the error result is preferred over the exit status
+	writePlugin(t, "fail", "echo 'traceback' >&2\n"+
+		`echo '{"error": "invalid api key"}'`+"\nexit 1\n")
+	res, err = sitecfg.runPlugin("", "https://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, _, err = ParseResponse(sitecfg, res, "https://example.com")
+	if !errors.As(err, &uploaderr) || uploaderr.Message != "invalid api key" ||
+		uploaderr.StatusCode != 500 {
+
+		t.Errorf("got %v, want the error of the plugin", err)
+	}
+
+	sitecfg.PluginProtocol = "xml"
+	if _, err = sitecfg.runPlugin("", "x"); err == nil {
+		t.Error("an unknown protocol didn't fail")
+	}
+}
@@ -0,0 +1,196 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A ProgressFunc is called while a request body is being sent with the
+// number of bytes sent so far and the total size of the body, which is -1
+// when it isn't known
+type ProgressFunc func(sent, total int64)
+
+// progressUpdateInterval is the minimum time between two progress updates
+// shown to the user
+const progressUpdateInterval = 100 * time.Millisecond
+
+// progressReader calls progress every time data is read from r
+type progressReader struct {
+	r        io.ReadCloser
+	sent     int64
+	total    int64
+	progress ProgressFunc
+}
+
+func (p *progressReader) Read(b []byte) (n int, err error) {
+	n, err = p.r.Read(b)
+	if n > 0 {
+		p.sent += int64(n)
+		p.progress(p.sent, p.total)
+	}
+	return
+}
+
+func (p *progressReader) Close() error {
+	return p.r.Close()
+}
+
+// withProgress wraps r so that progress is called as it is read. r is
+// returned as is if progress is nil
+func withProgress(r io.ReadCloser, total int64,
+	progress ProgressFunc) io.ReadCloser {
+
+	if progress == nil {
+		return r
+	}
+	progress(0, total)
+	return &progressReader{r: r, total: total, progress: progress}
+}
+
+// combineProgress returns a ProgressFunc that calls all of the non-nil
+// funcs, or nil if there are none
+func combineProgress(funcs ...ProgressFunc) ProgressFunc {
+	var res []ProgressFunc
+	for _, f := range funcs {
+		if f != nil {
+			res = append(res, f)
+		}
+	}
+
+	if len(res) == 0 {
+		return nil
+	}
+
+	return func(sent, total int64) {
+		for _, f := range res {
+			f(sent, total)
+		}
+	}
+}
+
+// throttleProgress returns a ProgressFunc that only calls progress once
+// every progressUpdateInterval, plus once when the upload starts and once
+// when it completes, if the total size is known
+func throttleProgress(progress ProgressFunc) ProgressFunc {
+	var mutex sync.Mutex
+	var last time.Time
+
+	return func(sent, total int64) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		now := time.Now()
+		if sent > 0 && (total < 0 || sent < total) &&
+			now.Sub(last) < progressUpdateInterval {
+			return
+		}
+		last = now
+		progress(sent, total)
+	}
+}
+
+// isTerminal returns true if f is a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// FormatSize formats a size in bytes as a human readable string
+func FormatSize(size float64) string {
+	units := []string{"B", "KB", "MB", "GB"}
+	i := 0
+	for ; size >= 1024 && i < len(units)-1; i++ {
+		size /= 1024
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", size, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", size, units[i])
+}
+
+// formatETA formats a duration as m:ss
+func formatETA(d time.Duration) string {
+	secs := int64(d.Seconds() + 0.5)
+	return fmt.Sprintf("%d:%02d", secs/60, secs%60)
+}
+
+// Percentage returns how much of total has been sent, from 0 to 100
+func Percentage(sent, total int64) int {
+	if total <= 0 {
+		return 0
+	}
+	return int(sent * 100 / total)
+}
+
+// TerminalProgress returns a ProgressFunc that draws a progress bar with the
+// upload rate and ETA on stdout. Returns nil if silent is true or stdout is
+// not a terminal
+func TerminalProgress(silent bool) ProgressFunc {
+	if silent || !isTerminal(os.Stdout) {
+		return nil
+	}
+
+	const barWidth = 30
+	var start time.Time
+	done := false
+
+	return throttleProgress(func(sent, total int64) {
+		// the body starts over if the request is sent again
+		if sent == 0 {
+			start = time.Now()
+			done = false
+		}
+		if done {
+			return
+		}
+
+		percent := Percentage(sent, total)
+		filled := barWidth * percent / 100
+		bar := strings.Repeat("#", filled) +
+			strings.Repeat("-", barWidth-filled)
+
+		elapsed := time.Since(start)
+		status := ""
+		if elapsed > 0 && sent > 0 && total < 0 {
+			rate := float64(sent) / elapsed.Seconds()
+			status = fmt.Sprintf("%s %s/s", FormatSize(float64(sent)),
+				FormatSize(rate))
+		} else if elapsed > 0 && sent > 0 {
+			rate := float64(sent) / elapsed.Seconds()
+			eta := time.Duration(float64(total-sent) / rate *
+				float64(time.Second))
+			status = fmt.Sprintf("%s/s ETA %s", FormatSize(rate),
+				formatETA(eta))
+		}
+
+		// trailing spaces clear leftovers of a longer previous line
+		fmt.Printf("\r[%s] %3d%% %s    ", bar, percent, status)
+
+		if total > 0 && sent >= total {
+			done = true
+			fmt.Println()
+		}
+	})
+}
@@ -0,0 +1,156 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// progressCalls records the calls to a ProgressFunc
+type progressCalls [][2]int64
+
+func (c *progressCalls) progress(sent, total int64) {
+	*c = append(*c, [2]int64{sent, total})
+}
+
+// readChunks reads r to the end, at most n bytes at a time
+func readChunks(t *testing.T, r io.Reader, n int) []byte {
+	var res []byte
+	buf := make([]byte, n)
+	for {
+		read, err := r.Read(buf)
+		res = append(res, buf[:read]...)
+		if err == io.EOF {
+			return res
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestProgressReader(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 10000)
+
+	tests := []struct {
+		name  string
+		total int64
+		want  progressCalls
+	}{
+		{"known size", 10000, progressCalls{
+			{0, 10000}, {3000, 10000}, {6000, 10000}, {9000, 10000},
+			{10000, 10000},
+		}},
+		{"unknown size", -1, progressCalls{
+			{0, -1}, {3000, -1}, {6000, -1}, {9000, -1}, {10000, -1},
+		}},
+	}
+
+	for _, test := range tests {
+		var calls progressCalls
+		r := withProgress(ioutil.NopCloser(bytes.NewReader(data)),
+			test.total, calls.progress)
+
+		if got := readChunks(t, r, 3000); !bytes.Equal(got, data) {
+			t.Errorf("%s: read %d bytes, want %d", test.name, len(got),
+				len(data))
+		}
+		if err := r.Close(); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(calls, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, calls, test.want)
+		}
+
+		last := calls[len(calls)-1]
+		want := 100
+		if test.total < 0 {
+			want = 0
+		}
+		if p := Percentage(last[0], last[1]); p != want {
+			t.Errorf("%s: ended at %d%%, want %d%%", test.name, p, want)
+		}
+	}
+
+	r := ioutil.NopCloser(bytes.NewReader(data))
+	if withProgress(r, 10000, nil) != r {
+		t.Error("the body was wrapped without a ProgressFunc")
+	}
+}
+
+func TestThrottleProgress(t *testing.T) {
+	for _, total := range []int64{1000, -1} {
+		var calls progressCalls
+		progress := throttleProgress(calls.progress)
+		for sent := int64(0); sent <= 1000; sent += 100 {
+			progress(sent, total)
+		}
+
+		// the start is always shown, and so is the end if it's known
+		want := progressCalls{{0, total}}
+		if total > 0 {
+			want = append(want, [2]int64{1000, total})
+		}
+		if !reflect.DeepEqual(calls, want) {
+			t.Errorf("total %d: got %v, want %v", total, calls, want)
+		}
+
+		time.Sleep(progressUpdateInterval)
+		progress(1100, total)
+		if len(calls) != len(want)+1 {
+			t.Errorf("total %d: an update after the interval was dropped",
+				total)
+		}
+	}
+}
+
+func TestCombineProgress(t *testing.T) {
+	if combineProgress(nil, nil) != nil {
+		t.Error("got a ProgressFunc with nothing to call")
+	}
+
+	var a, b progressCalls
+	combineProgress(a.progress, nil, b.progress)(5, 10)
+	want := progressCalls{{5, 10}}
+	if !reflect.DeepEqual(a, want) || !reflect.DeepEqual(b, want) {
+		t.Errorf("got %v and %v, want %v for both", a, b, want)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := map[float64]string{
+		0:                 "0 B",
+		1023:              "1023 B",
+		1536:              "1.5 KB",
+		5 * 1024 * 1024:   "5.0 MB",
+		3 << 40:           "3072.0 GB",
+		1024 * 1024 * 1.2: "1.2 MB",
+	}
+	for size, want := range tests {
+		if got := FormatSize(size); got != want {
+			t.Errorf("FormatSize(%v) = %q, want %q", size, got, want)
+		}
+	}
+
+	if got := formatETA(125*time.Second + 600*time.Millisecond); got != "2:06" {
+		t.Errorf("formatETA = %q, want 2:06", got)
+	}
+}
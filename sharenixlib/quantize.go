@@ -0,0 +1,241 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// colors are reduced to 5 bits per channel before quantizing, which keeps
+// the histogram small enough to be fast on full screen images
+const (
+	quantBits   = 5
+	quantShift  = 8 - quantBits
+	quantColors = 1 << (quantBits * 3)
+)
+
+func quantKey(r, g, b uint8) int {
+	return int(r>>quantShift)<<(quantBits*2) |
+		int(g>>quantShift)<<quantBits |
+		int(b>>quantShift)
+}
+
+// quantTransparent is the alpha below which a pixel is considered fully
+// transparent when quantizing
+const quantTransparent = 0x80
+
+// quantHistogram counts the opaque pixels in each bucket and sums their real
+// channel values so that the palette isn't skewed towards the lower edge of
+// the buckets
+type quantHistogram struct {
+	count       []int
+	r, g, b     []int
+	transparent bool
+}
+
+// quantAverage returns sum/count rounded to the nearest channel value
+func quantAverage(sum, count int) uint8 {
+	return uint8((sum + count/2) / count)
+}
+
+func newQuantHistogram(img *image.RGBA, rect image.Rectangle) (
+	h *quantHistogram) {
+
+	h = &quantHistogram{
+		count: make([]int, quantColors),
+		r:     make([]int, quantColors),
+		g:     make([]int, quantColors),
+		b:     make([]int, quantColors),
+	}
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		i := img.PixOffset(rect.Min.X, y)
+		for x := rect.Min.X; x < rect.Max.X; x, i = x+1, i+4 {
+			if img.Pix[i+3] < quantTransparent {
+				h.transparent = true
+				continue
+			}
+			k := quantKey(img.Pix[i], img.Pix[i+1], img.Pix[i+2])
+			h.count[k]++
+			h.r[k] += int(img.Pix[i])
+			h.g[k] += int(img.Pix[i+1])
+			h.b[k] += int(img.Pix[i+2])
+		}
+	}
+	return
+}
+
+type quantBox struct {
+	keys []int
+}
+
+// quantChannel returns the value of channel c (0-2) of a histogram key
+func quantChannel(key, c int) int {
+	mask := 1<<quantBits - 1
+	return key >> (quantBits * (2 - c)) & mask
+}
+
+// widest returns the channel with the widest range in the box and its range
+func (b *quantBox) widest() (channel, width int) {
+	for c := 0; c < 3; c++ {
+		lo, hi := 1<<quantBits, -1
+		for _, k := range b.keys {
+			v := quantChannel(k, c)
+			if v < lo {
+				lo = v
+			}
+			if v > hi {
+				hi = v
+			}
+		}
+		if hi-lo > width {
+			channel, width = c, hi-lo
+		}
+	}
+	return
+}
+
+// MedianCut builds a palette of at most n colors that represents the pixels
+// of img inside rect using the median cut algorithm.
+// If any of the pixels are transparent, the last entry of the palette is
+// reserved for them.
+func MedianCut(img *image.RGBA, rect image.Rectangle, n int) color.Palette {
+	rect = rect.Intersect(img.Bounds())
+	h := newQuantHistogram(img, rect)
+	return h.palette(n)
+}
+
+func (h *quantHistogram) palette(n int) (pal color.Palette) {
+	hist := h.count
+	if h.transparent {
+		n--
+		defer func() { pal = append(pal, color.RGBA{}) }()
+	}
+
+	all := &quantBox{}
+	for k, count := range hist {
+		if count > 0 {
+			all.keys = append(all.keys, k)
+		}
+	}
+
+	if len(all.keys) == 0 {
+		if h.transparent {
+			return color.Palette{}
+		}
+		return color.Palette{color.Black}
+	}
+
+	boxes := []*quantBox{all}
+	for len(boxes) < n {
+		// split the box with the widest channel range at the median pixel
+		best, bestWidth, bestChannel := -1, 0, 0
+		for i, b := range boxes {
+			if len(b.keys) < 2 {
+				continue
+			}
+			c, w := b.widest()
+			if w > bestWidth {
+				best, bestWidth, bestChannel = i, w, c
+			}
+		}
+		if best == -1 {
+			break
+		}
+
+		b := boxes[best]
+		sort.Slice(b.keys, func(i, j int) bool {
+			return quantChannel(b.keys[i], bestChannel) <
+				quantChannel(b.keys[j], bestChannel)
+		})
+
+		total := 0
+		for _, k := range b.keys {
+			total += hist[k]
+		}
+		half, split := 0, len(b.keys)-1
+		for i, k := range b.keys[:len(b.keys)-1] {
+			half += hist[k]
+			if half*2 >= total {
+				split = i + 1
+				break
+			}
+		}
+
+		boxes[best] = &quantBox{b.keys[:split]}
+		boxes = append(boxes, &quantBox{b.keys[split:]})
+	}
+
+	// each palette entry is the average of the pixels in its box
+	pal = make(color.Palette, len(boxes))
+	for i, b := range boxes {
+		var r, g, bl, total int
+		for _, k := range b.keys {
+			r += h.r[k]
+			g += h.g[k]
+			bl += h.b[k]
+			total += hist[k]
+		}
+		pal[i] = color.RGBA{quantAverage(r, total), quantAverage(g, total),
+			quantAverage(bl, total), 0xFF}
+	}
+
+	return pal
+}
+
+// Quantize converts the pixels of img inside rect to a paletted image with
+// at most n colors.
+// Transparent pixels are mapped to a transparent palette entry.
+// The returned image has the same bounds as rect.
+func Quantize(img *image.RGBA, rect image.Rectangle, n int) *image.Paletted {
+	rect = rect.Intersect(img.Bounds())
+	h := newQuantHistogram(img, rect)
+	pal := h.palette(n)
+	res := image.NewPaletted(rect, pal)
+
+	opaque := pal
+	if h.transparent {
+		opaque = pal[:len(pal)-1]
+	}
+
+	// nearest palette entry for each histogram bucket, filled lazily
+	lookup := make([]int16, quantColors)
+	for i := range lookup {
+		lookup[i] = -1
+	}
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		i := img.PixOffset(rect.Min.X, y)
+		j := res.PixOffset(rect.Min.X, y)
+		for x := rect.Min.X; x < rect.Max.X; x, i, j = x+1, i+4, j+1 {
+			if img.Pix[i+3] < quantTransparent {
+				res.Pix[j] = uint8(len(pal) - 1)
+				continue
+			}
+			k := quantKey(img.Pix[i], img.Pix[i+1], img.Pix[i+2])
+			if lookup[k] == -1 {
+				n := h.count[k]
+				c := color.RGBA{quantAverage(h.r[k], n),
+					quantAverage(h.g[k], n), quantAverage(h.b[k], n), 0xFF}
+				lookup[k] = int16(opaque.Index(c))
+			}
+			res.Pix[j] = uint8(lookup[k])
+		}
+	}
+
+	return res
+}
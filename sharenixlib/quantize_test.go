@@ -0,0 +1,79 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestQuantizeExactColors(t *testing.T) {
+	colors := []color.RGBA{
+		{0xFF, 0xFF, 0xFF, 0xFF},
+		{0, 0, 0, 0xFF},
+		{0xFF, 0, 0, 0xFF},
+		{13, 200, 77, 0xFF},
+		{0x7F, 0x80, 0x81, 0xFF},
+	}
+	img := image.NewRGBA(image.Rect(0, 0, len(colors), 3))
+	for x, c := range colors {
+		for y := 0; y < 3; y++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	res := Quantize(img, img.Bounds(), 16)
+	if len(res.Palette) != len(colors) {
+		t.Errorf("got %d palette entries, want %d", len(res.Palette),
+			len(colors))
+	}
+	for x, want := range colors {
+		if got := res.At(x, 1); got != want {
+			t.Errorf("%d: got %v, want %v", x, got, want)
+		}
+	}
+}
+
+func TestQuantizeTransparent(t *testing.T) {
+	// two screens with a transparent gap between them, like composeScreens
+	img := image.NewRGBA(image.Rect(0, 0, 6, 2))
+	for y := 0; y < 2; y++ {
+		img.SetRGBA(0, y, color.RGBA{0, 0, 0, 0xFF})
+		img.SetRGBA(5, y, color.RGBA{0xFF, 0xFF, 0xFF, 0xFF})
+	}
+
+	res := Quantize(img, img.Bounds(), 2)
+	if len(res.Palette) != 2 {
+		t.Errorf("got %d palette entries, want 2", len(res.Palette))
+	}
+	if _, _, _, a := res.At(2, 0).RGBA(); a != 0 {
+		t.Errorf("the gap has alpha %d, want 0", a)
+	}
+	// only one entry is left for the screens
+	if _, _, _, a := res.At(0, 1).RGBA(); a != 0xFFFF {
+		t.Errorf("a screen pixel has alpha %d, want it opaque", a)
+	}
+
+	res = Quantize(img, img.Bounds(), 3)
+	for x, want := range []color.RGBA{{0, 0, 0, 0xFF}, {}, {}, {}, {},
+		{0xFF, 0xFF, 0xFF, 0xFF}} {
+
+		if got := res.At(x, 0); got != want {
+			t.Errorf("%d: got %v, want %v", x, got, want)
+		}
+	}
+}
@@ -0,0 +1,399 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/BurntSushi/xgb"
+	"image"
+	"image/gif"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// A FrameEncoder receives the frames captured by RecordRect
+type FrameEncoder interface {
+	// WriteFrame is called for each captured frame with the time elapsed
+	// since the start of the recording. the encoder is free to keep img
+	WriteFrame(img *image.RGBA, t time.Duration) error
+	// Close is called once after the last frame
+	Close() error
+}
+
+// A GifEncoder encodes frames to an animated gif.
+// Each frame only stores the area that changed since the previous frame,
+// quantized to its own 256 color palette.
+type GifEncoder struct {
+	w      io.Writer
+	fps    float64
+	anim   gif.GIF
+	prev   *image.RGBA
+	prevt  time.Duration
+	lastt  time.Duration
+	closed bool
+}
+
+// NewGifEncoder creates a GifEncoder that writes to w when closed
+func NewGifEncoder(w io.Writer, fps float64) *GifEncoder {
+	return &GifEncoder{w: w, fps: fps}
+}
+
+// changedRect returns the bounding box of the pixels that differ between
+// two images of the same size
+func changedRect(a, b *image.RGBA) (r image.Rectangle) {
+	bounds := a.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		ia := a.PixOffset(bounds.Min.X, y)
+		ib := b.PixOffset(bounds.Min.X, y)
+		rowa := a.Pix[ia : ia+bounds.Dx()*4]
+		rowb := b.Pix[ib : ib+bounds.Dx()*4]
+		if bytes.Equal(rowa, rowb) {
+			continue
+		}
+
+		minx, maxx := bounds.Max.X, bounds.Min.X
+		for x := 0; x < bounds.Dx(); x++ {
+			if !bytes.Equal(rowa[x*4:x*4+3], rowb[x*4:x*4+3]) {
+				if x+bounds.Min.X < minx {
+					minx = x + bounds.Min.X
+				}
+				maxx = x + bounds.Min.X + 1
+			}
+		}
+
+		r = r.Union(image.Rect(minx, y, maxx, y+1))
+	}
+	return
+}
+
+// centiseconds converts a duration to gif frame delay units
+func centiseconds(d time.Duration) int {
+	cs := int(d / (10 * time.Millisecond))
+	if cs < 2 {
+		// most viewers treat delays below 2 as 10
+		cs = 2
+	}
+	return cs
+}
+
+func (e *GifEncoder) WriteFrame(img *image.RGBA, t time.Duration) error {
+	e.lastt = t
+
+	rect := img.Bounds()
+	if e.prev != nil {
+		rect = changedRect(e.prev, img)
+		if rect.Empty() {
+			// identical frame, the previous one is just shown longer
+			return nil
+		}
+		last := len(e.anim.Delay) - 1
+		e.anim.Delay[last] = centiseconds(t - e.prevt)
+	} else {
+		e.anim.Config.Width = rect.Dx()
+		e.anim.Config.Height = rect.Dy()
+	}
+
+	e.anim.Image = append(e.anim.Image, Quantize(img, rect, 256))
+	e.anim.Delay = append(e.anim.Delay, 0)
+	e.anim.Disposal = append(e.anim.Disposal, gif.DisposalNone)
+	e.prev = img
+	e.prevt = t
+	return nil
+}
+
+func (e *GifEncoder) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+
+	if len(e.anim.Image) == 0 {
+		return fmt.Errorf("No frames were recorded")
+	}
+
+	// the last frame lasts until the end of the recording
+	last := len(e.anim.Delay) - 1
+	e.anim.Delay[last] = centiseconds(e.lastt - e.prevt +
+		time.Duration(float64(time.Second)/e.fps))
+
+	DebugPrintln("Encoding", len(e.anim.Image), "gif frames")
+	return gif.EncodeAll(e.w, &e.anim)
+}
+
+// A FFmpegEncoder pipes raw frames to a local ffmpeg process.
+// Frames are repeated as needed to keep a constant frame rate when the
+// capture falls behind.
+type FFmpegEncoder struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	fps    float64
+	frames int
+	stderr bytes.Buffer
+}
+
+// ffmpegCodecArgs returns the output arguments for each supported format
+func ffmpegCodecArgs(format string) ([]string, error) {
+	switch format {
+	case "gif":
+		return []string{"-vf",
+			"split[a][b];[a]palettegen[p];[b][p]paletteuse"}, nil
+	case "webm":
+		return []string{"-c:v", "libvpx-vp9", "-b:v", "0", "-crf", "35"}, nil
+	case "mp4":
+		// yuv420p needs even dimensions
+		return []string{"-c:v", "libx264", "-pix_fmt", "yuv420p",
+			"-vf", "pad=ceil(iw/2)*2:ceil(ih/2)*2"}, nil
+	}
+	return nil, fmt.Errorf("Unsupported recording format: %s", format)
+}
+
+// NewFFmpegEncoder starts ffmpeg and returns an encoder that feeds it
+// frames of the given size.
+// command: path or name of the ffmpeg executable
+// output: the file ffmpeg will write to
+// format: gif, webm or mp4
+func NewFFmpegEncoder(command, output, format string, width, height int,
+	fps float64) (e *FFmpegEncoder, err error) {
+
+	args, err := ffmpegArgs(output, format, width, height, fps)
+	if err != nil {
+		return
+	}
+
+	e = &FFmpegEncoder{fps: fps}
+	e.cmd = exec.Command(command, args...)
+	e.cmd.Stderr = &e.stderr
+	DebugPrintln(e.cmd.Args)
+
+	e.stdin, err = e.cmd.StdinPipe()
+	if err != nil {
+		return
+	}
+
+	err = e.cmd.Start()
+	return
+}
+
+// ffmpegArgs returns the ffmpeg arguments that read raw rgba frames of the
+// given size from stdin and encode them to output
+func ffmpegArgs(output, format string, width, height int, fps float64) (
+	args []string, err error) {
+
+	codec, err := ffmpegCodecArgs(format)
+	if err != nil {
+		return
+	}
+
+	args = []string{
+		"-y", "-loglevel", "error",
+		"-f", "rawvideo",
+		"-pix_fmt", "rgba",
+		"-video_size", fmt.Sprintf("%dx%d", width, height),
+		"-framerate", strconv.FormatFloat(fps, 'f', -1, 64),
+		"-i", "-",
+	}
+	args = append(args, codec...)
+	args = append(args, output)
+	return
+}
+
+func (e *FFmpegEncoder) WriteFrame(img *image.RGBA, t time.Duration) error {
+	// frame number this capture belongs to at a constant frame rate
+	target := int(t.Seconds()*e.fps) + 1
+	if target <= e.frames {
+		target = e.frames + 1
+	}
+
+	bounds := img.Bounds()
+	for ; e.frames < target; e.frames++ {
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			i := img.PixOffset(bounds.Min.X, y)
+			_, err := e.stdin.Write(img.Pix[i : i+bounds.Dx()*4])
+			if err != nil {
+				return fmt.Errorf("ffmpeg: %v %s", err, e.stderr.String())
+			}
+		}
+	}
+
+	return nil
+}
+
+func (e *FFmpegEncoder) Close() error {
+	e.stdin.Close()
+	if err := e.cmd.Wait(); err != nil {
+		return fmt.Errorf("ffmpeg: %v %s", err, e.stderr.String())
+	}
+	return nil
+}
+
+// RecordRect captures a section of the default screen fps times per second
+// and passes the frames to enc until stop receives a value or maxDuration
+// elapses. maxDuration <= 0 means no limit.
+// enc is closed before returning.
+func RecordRect(X *xgb.Conn, rect image.Rectangle, fps float64,
+	maxDuration time.Duration, stop <-chan bool, enc FrameEncoder) (
+	err error) {
+
+	defer func() {
+		closeerr := enc.Close()
+		if err == nil {
+			err = closeerr
+		}
+	}()
+
+	if fps <= 0 {
+		err = fmt.Errorf("Invalid frame rate: %v", fps)
+		return
+	}
+
+	var timeout <-chan time.Time
+	if maxDuration > 0 {
+		timeout = time.After(maxDuration)
+	}
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / fps))
+	defer ticker.Stop()
+
+	start := time.Now()
+	for {
+		var frame *image.RGBA
+		frame, err = CaptureRect(X, -1, rect)
+		if err != nil {
+			return
+		}
+
+		if err = enc.WriteFrame(frame, time.Since(start)); err != nil {
+			return
+		}
+
+		select {
+		case <-stop:
+			DebugPrintln("Recording stopped after", time.Since(start))
+			return
+		case <-timeout:
+			DebugPrintln("Recording reached the duration limit")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// recordingPidFile returns the path of the file that holds the pid of the
+// running recording, if any
+func recordingPidFile() (file string, err error) {
+	storage, err := GetStorageDir()
+	if err != nil {
+		return
+	}
+	file = path.Join(storage, ".recording")
+	return
+}
+
+// StopRecording asks a running sharenix recording to stop.
+// Returns false if no recording was running.
+// The pid file is only trusted while the recording holds its lock, a file
+// left behind by a recording that crashed is removed instead, since its pid
+// could belong to any other process by now.
+func StopRecording() (stopped bool, err error) {
+	file, err := recordingPidFile()
+	if err != nil {
+		return
+	}
+
+	f, openerr := os.Open(file)
+	if openerr != nil {
+		return
+	}
+	defer f.Close()
+
+	lockerr := syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+	if lockerr == nil {
+		DebugPrintln("Removing stale", file)
+		err = os.Remove(file)
+		return
+	}
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return
+	}
+
+	pid, err := strconv.Atoi(string(bytes.TrimSpace(data)))
+	if err != nil {
+		err = fmt.Errorf("Invalid pid in %s: %v", file, err)
+		return
+	}
+
+	if err = syscall.Kill(pid, syscall.SIGUSR1); err != nil {
+		return
+	}
+
+	DebugPrintln("Sent SIGUSR1 to", pid)
+	return true, nil
+}
+
+// recordingBegin stores our pid so that StopRecording can find us, and
+// locks the pid file until recordingEnd is called. the lock goes away with
+// the process if it crashes
+func recordingBegin() (f *os.File, err error) {
+	file, err := recordingPidFile()
+	if err != nil {
+		return
+	}
+
+	f, err = os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			f.Close()
+			f = nil
+		}
+	}()
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		err = fmt.Errorf("Another recording is already running")
+		return
+	}
+
+	if err = f.Truncate(0); err != nil {
+		return
+	}
+	_, err = f.WriteString(strconv.Itoa(os.Getpid()))
+	return
+}
+
+// recordingEnd removes the pid file created by recordingBegin and releases
+// its lock
+func recordingEnd(f *os.File) (err error) {
+	defer f.Close()
+
+	file, err := recordingPidFile()
+	if err != nil {
+		return
+	}
+	return os.Remove(file)
+}
@@ -0,0 +1,247 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// withStorage points the storage dir to a temporary home with an empty
+// config for the duration of the test
+func withStorage(t *testing.T) string {
+	home := t.TempDir()
+	err := ioutil.WriteFile(path.Join(home, ".sharenix.json"), []byte("{}"),
+		0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldhome := os.Getenv("HOME")
+	os.Setenv("HOME", home)
+	t.Cleanup(func() { os.Setenv("HOME", oldhome) })
+	return path.Join(home, "sharenix")
+}
+
+func TestStopRecordingStaleFile(t *testing.T) {
+	storage := withStorage(t)
+	if err := os.MkdirAll(storage, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// nobody holds the lock, so the pid must not be signaled even though
+	// it belongs to a live process
+	file := path.Join(storage, ".recording")
+	err := ioutil.WriteFile(file, []byte("1"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stopped, err := StopRecording()
+	if err != nil || stopped {
+		t.Fatalf("StopRecording() = %v, %v, want false, nil", stopped, err)
+	}
+	if _, err = os.Stat(file); !os.IsNotExist(err) {
+		t.Error("The stale pid file wasn't removed")
+	}
+}
+
+func TestStopRecordingRunning(t *testing.T) {
+	withStorage(t)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1)
+	defer signal.Stop(sigs)
+
+	pidfile, err := recordingBegin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = recordingBegin(); err == nil {
+		t.Error("A second recording was allowed to start")
+	}
+
+	stopped, err := StopRecording()
+	if err != nil || !stopped {
+		t.Fatalf("StopRecording() = %v, %v, want true, nil", stopped, err)
+	}
+
+	select {
+	case <-sigs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The recording was never signaled")
+	}
+
+	if err = recordingEnd(pidfile); err != nil {
+		t.Fatal(err)
+	}
+
+	stopped, err = StopRecording()
+	if err != nil || stopped {
+		t.Errorf("StopRecording() after the end = %v, %v, want false, nil",
+			stopped, err)
+	}
+}
+
+func TestGifEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewGifEncoder(&buf, 10)
+
+	frames := []struct {
+		t       time.Duration
+		changed image.Rectangle
+	}{
+		{0, image.Rectangle{}},
+		// identical to the previous frame
+		{100 * time.Millisecond, image.Rectangle{}},
+		{300 * time.Millisecond, image.Rect(2, 3, 4, 6)},
+		{350 * time.Millisecond, image.Rect(9, 9, 10, 10)},
+	}
+
+	for _, f := range frames {
+		img := solid(10, 10, white)
+		for y := f.changed.Min.Y; y < f.changed.Max.Y; y++ {
+			for x := f.changed.Min.X; x < f.changed.Max.X; x++ {
+				img.Set(x, y, red)
+			}
+		}
+		if err := enc.WriteFrame(img, f.t); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	anim, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if anim.Config.Width != 10 || anim.Config.Height != 10 {
+		t.Errorf("the gif is %dx%d, want 10x10", anim.Config.Width,
+			anim.Config.Height)
+	}
+
+	// the last frame changes the red area back to white
+	bounds := []image.Rectangle{
+		image.Rect(0, 0, 10, 10),
+		image.Rect(2, 3, 4, 6),
+		image.Rect(2, 3, 10, 10),
+	}
+	if len(anim.Image) != len(bounds) {
+		t.Fatalf("got %d frames, want %d", len(anim.Image), len(bounds))
+	}
+	for i, want := range bounds {
+		if got := anim.Image[i].Bounds(); got != want {
+			t.Errorf("frame %d covers %v, want %v", i, got, want)
+		}
+	}
+	if got := color.RGBAModel.Convert(anim.Image[1].At(3, 4)); got != red {
+		t.Errorf("frame 1 is %v, want red", got)
+	}
+
+	// the last frame lasts one frame interval
+	if want := []int{30, 5, 10}; !reflect.DeepEqual(anim.Delay, want) {
+		t.Errorf("the delays are %v, want %v", anim.Delay, want)
+	}
+}
+
+func TestGifEncoderNoFrames(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewGifEncoder(&buf, 10).Close(); err == nil {
+		t.Error("an empty recording didn't fail")
+	}
+}
+
+func TestFFmpegArgs(t *testing.T) {
+	args, err := ffmpegArgs("/tmp/out.mp4", "mp4", 641, 480, 29.97)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"-y", "-loglevel", "error",
+		"-f", "rawvideo",
+		"-pix_fmt", "rgba",
+		"-video_size", "641x480",
+		"-framerate", "29.97",
+		"-i", "-",
+		"-c:v", "libx264", "-pix_fmt", "yuv420p",
+		"-vf", "pad=ceil(iw/2)*2:ceil(ih/2)*2",
+		"/tmp/out.mp4",
+	}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("got %q, want %q", args, want)
+	}
+
+	for _, format := range []string{"gif", "webm"} {
+		args, err = ffmpegArgs("out."+format, format, 10, 10, 15)
+		if err != nil || args[len(args)-1] != "out."+format {
+			t.Errorf("%s: got %q, %v", format, args, err)
+		}
+	}
+
+	if _, err = ffmpegArgs("out.avi", "avi", 10, 10, 15); err == nil {
+		t.Error("an unsupported format didn't fail")
+	}
+}
+
+func TestFFmpegEncoderFrameRate(t *testing.T) {
+	dir := t.TempDir()
+
+	// stands in for ffmpeg and saves the raw frames to the output file
+	command := path.Join(dir, "ffmpeg")
+	err := ioutil.WriteFile(command, []byte("#!/bin/sh\n"+
+		"for out; do :; done\n"+
+		"cat > \"$out\"\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := path.Join(dir, "out.webm")
+	enc, err := NewFFmpegEncoder(command, output, "webm", 2, 2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the capture fell behind by two frames, which are repeated
+	for _, d := range []time.Duration{0, 350 * time.Millisecond} {
+		if err = enc.WriteFrame(solid(2, 2, red), d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frames := len(data) / (2 * 2 * 4); frames != 4 {
+		t.Errorf("got %d frames, want 4", frames)
+	}
+}
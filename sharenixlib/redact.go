@@ -0,0 +1,107 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+	"strings"
+)
+
+// ParseRedactRect parses a redaction rectangle in x,y,w,h format
+func ParseRedactRect(s string) (rect image.Rectangle, err error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		err = fmt.Errorf("Invalid redaction rectangle %q, expected x,y,w,h",
+			s)
+		return
+	}
+
+	var v [4]int
+	for i, p := range parts {
+		v[i], err = strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			err = fmt.Errorf("Invalid redaction rectangle %q: %v", s, err)
+			return
+		}
+	}
+
+	if v[2] <= 0 || v[3] <= 0 {
+		err = fmt.Errorf("Invalid redaction rectangle %q: empty area", s)
+		return
+	}
+
+	rect = image.Rect(v[0], v[1], v[0]+v[2], v[1]+v[3])
+	return
+}
+
+// Pixelate replaces each blockSize x blockSize block of img inside rect with
+// its average color
+func Pixelate(img *image.RGBA, rect image.Rectangle, blockSize int) {
+	if blockSize < 2 {
+		blockSize = 2
+	}
+
+	rect = rect.Intersect(img.Bounds())
+	for by := rect.Min.Y; by < rect.Max.Y; by += blockSize {
+		for bx := rect.Min.X; bx < rect.Max.X; bx += blockSize {
+			block := image.Rect(bx, by, bx+blockSize, by+blockSize).
+				Intersect(rect)
+
+			var sum [4]int
+			for y := block.Min.Y; y < block.Max.Y; y++ {
+				i := img.PixOffset(block.Min.X, y)
+				for x := block.Min.X; x < block.Max.X; x, i = x+1, i+4 {
+					for c := 0; c < 4; c++ {
+						sum[c] += int(img.Pix[i+c])
+					}
+				}
+			}
+
+			n := block.Dx() * block.Dy()
+			avg := color.RGBA{uint8(sum[0] / n), uint8(sum[1] / n),
+				uint8(sum[2] / n), uint8(sum[3] / n)}
+			draw.Draw(img, block, image.NewUniform(avg), image.ZP, draw.Src)
+		}
+	}
+}
+
+// Redact hides the given areas of img, which are relative to its top left
+// corner.
+// style: pixelate (blocks of blockSize pixels) or black
+func Redact(img *image.RGBA, rects []image.Rectangle, style string,
+	blockSize int) (err error) {
+
+	for _, r := range rects {
+		r = r.Add(img.Bounds().Min)
+		DebugPrintln("Redacting", r, "with", style)
+
+		switch style {
+		case "", "pixelate":
+			Pixelate(img, r, blockSize)
+		case "black":
+			draw.Draw(img, r.Intersect(img.Bounds()), image.Black, image.ZP,
+				draw.Src)
+		default:
+			return fmt.Errorf("Invalid redaction style: %s", style)
+		}
+	}
+
+	return
+}
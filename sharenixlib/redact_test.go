@@ -0,0 +1,156 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// checkerboard returns an image with alternating black and white pixels
+func checkerboard(w, h int) *image.RGBA {
+	img := solid(w, h, color.Black)
+	for y := 0; y < h; y++ {
+		for x := (y + 1) % 2; x < w; x += 2 {
+			img.SetRGBA(x, y, white)
+		}
+	}
+	return img
+}
+
+func TestParseRedactRect(t *testing.T) {
+	rect, err := ParseRedactRect(" 10, 20,30 ,40")
+	if err != nil || rect != image.Rect(10, 20, 40, 60) {
+		t.Errorf("got %v, %v", rect, err)
+	}
+
+	for _, s := range []string{"1,2,3", "1,2,3,x", "1,2,0,4", "1,2,3,-4"} {
+		if _, err = ParseRedactRect(s); err == nil {
+			t.Errorf("%q didn't fail", s)
+		}
+	}
+}
+
+func TestPixelateEdges(t *testing.T) {
+	// the red channel is 10 times the x coordinate, the green channel 10
+	// times the y coordinate
+	img := image.NewRGBA(image.Rect(0, 0, 5, 5))
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 5; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(10 * x), uint8(10 * y), 0,
+				0xFF})
+		}
+	}
+
+	Pixelate(img, img.Bounds(), 2)
+
+	// blocks on the right and bottom edges only average what's left
+	tests := map[image.Point]color.RGBA{
+		{0, 0}: {5, 5, 0, 0xFF},
+		{3, 2}: {25, 25, 0, 0xFF},
+		{4, 0}: {40, 5, 0, 0xFF},
+		{1, 4}: {5, 40, 0, 0xFF},
+		{4, 4}: {40, 40, 0, 0xFF},
+	}
+	for pt, want := range tests {
+		if got := img.RGBAAt(pt.X, pt.Y); got != want {
+			t.Errorf("%v is %v, want %v", pt, got, want)
+		}
+	}
+}
+
+func TestPixelateOutOfBounds(t *testing.T) {
+	img := checkerboard(6, 6)
+
+	// blocks start at the visible part of the area
+	Pixelate(img, image.Rect(-3, -3, 2, 2), 2)
+	Pixelate(img, image.Rect(10, 10, 20, 20), 2)
+
+	grey := color.RGBA{0x7F, 0x7F, 0x7F, 0xFF}
+	for y := 0; y < 6; y++ {
+		for x := 0; x < 6; x++ {
+			want := checkerboard(6, 6).RGBAAt(x, y)
+			if x < 2 && y < 2 {
+				want = grey
+			}
+			if got := img.RGBAAt(x, y); got != want {
+				t.Errorf("%d, %d is %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	black := color.RGBA{0, 0, 0, 0xFF}
+	grey := color.RGBA{0x7F, 0x7F, 0x7F, 0xFF}
+
+	tests := []struct {
+		name  string
+		style string
+		rects []image.Rectangle
+		color color.RGBA
+	}{
+		{"black", "black", []image.Rectangle{image.Rect(1, 2, 4, 5)}, black},
+		{"black out of bounds", "black",
+			[]image.Rectangle{image.Rect(-5, 6, 3, 20)}, black},
+		{"pixelate", "", []image.Rectangle{image.Rect(2, 2, 6, 4)}, grey},
+		{"pixelate out of bounds", "pixelate",
+			[]image.Rectangle{image.Rect(6, -2, 12, 2)}, grey},
+		// the second box pixelates blocks that are already pixelated
+		{"overlapping", "pixelate", []image.Rectangle{
+			image.Rect(0, 0, 4, 4), image.Rect(2, 2, 6, 6),
+		}, grey},
+		{"overlapping black", "black", []image.Rectangle{
+			image.Rect(0, 0, 4, 4), image.Rect(2, 2, 6, 6),
+		}, black},
+	}
+
+	orig := checkerboard(8, 8)
+	for _, test := range tests {
+		// the boxes are relative to the top left corner of the capture
+		capture := image.Rect(2, 2, 10, 10)
+		img := checkerboard(10, 10).SubImage(capture).(*image.RGBA)
+
+		err := Redact(img, test.rects, test.style, 2)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 8; x++ {
+				pt := image.Pt(x, y)
+				want := orig.RGBAAt(x, y)
+				for _, r := range test.rects {
+					if pt.In(r) {
+						want = test.color
+					}
+				}
+				if got := img.RGBAAt(x+2, y+2); got != want {
+					t.Errorf("%s: %v is %v, want %v", test.name, pt, got,
+						want)
+				}
+			}
+		}
+	}
+
+	img := checkerboard(4, 4)
+	err := Redact(img, []image.Rectangle{img.Bounds()}, "blur", 2)
+	if err == nil {
+		t.Error("an invalid style didn't fail")
+	}
+}
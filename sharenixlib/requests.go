@@ -17,16 +17,23 @@ package sharenixlib
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math/rand"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/textproto"
 	neturl "net/url"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
@@ -35,6 +42,31 @@ func escapeQuotes(s string) string {
 	return quoteEscaper.Replace(s)
 }
 
+// appendQuery adds params to the query string of rawurl
+func appendQuery(rawurl string, params map[string]string) string {
+	if len(params) == 0 {
+		return rawurl
+	}
+
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = neturl.QueryEscape(name) + "=" +
+			neturl.QueryEscape(params[name])
+	}
+
+	sep := "?"
+	if strings.Contains(rawurl, "?") {
+		sep = "&"
+	}
+	return rawurl + sep + strings.Join(parts, "&")
+}
+
 // SniffMimeType sniffs the mime type of a binary file by reading the
 // first 512 bytes
 func SniffMimeType(filePath string) (string, error) {
@@ -54,23 +86,402 @@ func SniffMimeType(filePath string) (string, error) {
 	return http.DetectContentType(first512[:n]), nil
 }
 
+// conditions under which a request is retried if the site doesn't specify
+// any
+var defaultRetryOn = []string{"network", "timeout", "502", "503", "504"}
+
+// retryBaseDelay is the delay before the first retry. it doubles with every
+// retry up to maxRetryDelay. tests make them shorter
+var (
+	retryBaseDelay = time.Second
+	maxRetryDelay  = 30 * time.Second
+)
+
+// rng is the random number generator of the package
+var rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// A RetryPolicy tells SendRequest how long to wait for a response and when a
+// failed request must be sent again
+type RetryPolicy struct {
+	// Timeout limits how long each attempt can take. 0 means no limit
+	Timeout time.Duration
+	// Retries is how many more times a failed request is sent. When they run
+	// out because of the response status, the last response is returned
+	// with the error so that its error message can still be shown
+	Retries int
+	// RetryOn lists what counts as a failure: status codes (503), classes of
+	// status codes (5xx), network for connection errors and timeout for
+	// requests that took longer than Timeout.
+	// defaultRetryOn is used if it's empty
+	RetryOn []string
+}
+
+func (p *RetryPolicy) conditions() []string {
+	if len(p.RetryOn) == 0 {
+		return defaultRetryOn
+	}
+	return p.RetryOn
+}
+
+// validate checks that all the RetryOn conditions are valid
+func (p *RetryPolicy) validate() error {
+	for _, cond := range p.conditions() {
+		switch {
+		case cond == "network", cond == "timeout":
+		case !validStatus(cond):
+			return fmt.Errorf("Invalid RetryOn condition: %q", cond)
+		}
+	}
+	return nil
+}
+
+// validStatus returns true if cond is a status code (503) or a class of
+// status codes (5xx)
+func validStatus(cond string) bool {
+	if len(cond) == 3 && strings.HasSuffix(cond, "xx") &&
+		cond[0] >= '1' && cond[0] <= '5' {
+
+		return true
+	}
+	code, err := strconv.Atoi(cond)
+	return err == nil && code >= 100 && code <= 599
+}
+
+// matchStatus returns true if code is the status code or in the class of
+// status codes cond
+func matchStatus(cond string, code int) bool {
+	status := strconv.Itoa(code)
+	return cond == status || (strings.HasSuffix(cond, "xx") &&
+		cond[0] == status[0])
+}
+
+// isTimeout returns true if err is caused by a timeout
+func isTimeout(err error) bool {
+	var neterr net.Error
+	return errors.As(err, &neterr) && neterr.Timeout()
+}
+
+// failed returns true if an attempt that returned res or err matches any of
+// the RetryOn conditions
+func (p *RetryPolicy) failed(res *http.Response, err error) bool {
+	for _, cond := range p.conditions() {
+		switch {
+		case err != nil:
+			timeout := isTimeout(err)
+			if (cond == "timeout" && timeout) ||
+				(cond == "network" && !timeout) {
+				return true
+			}
+		case matchStatus(cond, res.StatusCode):
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns how long to wait before the given retry, starting from 1.
+// the delay grows exponentially and is randomized so that clients don't
+// retry all at once
+func backoff(retry int) time.Duration {
+	d := maxRetryDelay
+	if retry <= 5 {
+		d = retryBaseDelay << uint(retry-1)
+	}
+	if d > maxRetryDelay {
+		d = maxRetryDelay
+	}
+	return d/2 + time.Duration(rng.Int63n(int64(d/2)+1))
+}
+
+// request body types, named after the Body setting of ShareX
+const (
+	BodyNone           = "None"
+	BodyMultipart      = "MultipartFormData"
+	BodyFormURLEncoded = "FormURLEncoded"
+	BodyJSON           = "JSON"
+	BodyXML            = "XML"
+	BodyBinary         = "Binary"
+)
+
+// a formFile is a file field of a multi-part form
+type formFile struct {
+	param string
+	path  string
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = c.w.Write(p)
+	c.n += int64(n)
+	return
+}
+
+// writeFilePart adds a file to a multipart form.
+// if sizeOnly is true, the file is not read and its size is returned
+// instead, so that the length of the form can be known in advance
+func writeFilePart(w *multipart.Writer, f formFile, sizeOnly bool) (
+	size int64, err error) {
+
+	realmime, err := SniffMimeType(f.path)
+	if err != nil {
+		return
+	}
+
+	// try opening the file
+	file, err := os.Open(f.path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	// create a multipart file header with the given param name and file
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition",
+		fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+			escapeQuotes(f.param), escapeQuotes(filepath.Base(f.path))))
+	h.Set("Content-Type", realmime)
+
+	formfile, err := w.CreatePart(h)
+	if err != nil {
+		return
+	}
+
+	if sizeOnly {
+		var info os.FileInfo
+		info, err = file.Stat()
+		if err != nil {
+			return
+		}
+		size = info.Size()
+		return
+	}
+
+	// write the file to the file header
+	size, err = io.Copy(formfile, file)
+	return
+}
+
+// writeForm writes a multi-part form with the given boundary, files and
+// fields to w and returns its length.
+// if sizeOnly is true, the files are not read but still counted in the
+// length
+func writeForm(w io.Writer, boundary string, files []formFile,
+	params map[string]string, sizeOnly bool) (n int64, err error) {
+
+	cw := &countingWriter{w: w}
+	mw := multipart.NewWriter(cw)
+	if err = mw.SetBoundary(boundary); err != nil {
+		return
+	}
+
+	var filesize int64
+	for _, f := range files {
+		var size int64
+		size, err = writeFilePart(mw, f, sizeOnly)
+		if err != nil {
+			return
+		}
+		if sizeOnly {
+			filesize += size
+		}
+	}
+
+	// the fields must come out in the same order every time for the length
+	// to be right, so they are sorted
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err = mw.WriteField(name, params[name]); err != nil {
+			return
+		}
+	}
+
+	err = mw.Close()
+	n = cw.n + filesize
+	return
+}
+
+// streamForm returns a reader that produces the multi-part form as it is
+// read, without holding the files in memory
+func streamForm(boundary string, files []formFile,
+	params map[string]string) io.ReadCloser {
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := writeForm(pw, boundary, files, params, false)
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// newFileRequest creates a request whose body is the contents of the file
+// at filePath
+func newFileRequest(method, url, filePath string, progress ProgressFunc) (
+	req *http.Request, err error) {
+
+	realmime, err := SniffMimeType(filePath)
+	if err != nil {
+		return
+	}
+
+	freader, err := os.Open(filePath)
+	if err != nil {
+		return
+	}
+
+	info, err := freader.Stat()
+	if err != nil {
+		freader.Close()
+		return
+	}
+
+	// the request takes ownership of the file and closes it
+	size := info.Size()
+	req, err = http.NewRequest(method, url,
+		withProgress(freader, size, progress))
+	if err != nil {
+		freader.Close()
+		return
+	}
+
+	req.ContentLength = size
+	req.GetBody = func() (io.ReadCloser, error) {
+		f, err := os.Open(filePath)
+		if err != nil {
+			return nil, err
+		}
+		return withProgress(f, size, progress), nil
+	}
+	req.Header.Set("Content-Type", realmime)
+	return
+}
+
+// newFormRequest creates a request whose body is a multi-part form with the
+// given files and fields
+func newFormRequest(method, url string, files []formFile,
+	fields map[string]string, progress ProgressFunc) (req *http.Request,
+	err error) {
+
+	boundary := multipart.NewWriter(ioutil.Discard).Boundary()
+
+	length, err := writeForm(ioutil.Discard, boundary, files, fields, true)
+	if err != nil {
+		return
+	}
+
+	body := withProgress(streamForm(boundary, files, fields), length,
+		progress)
+	req, err = http.NewRequest(method, url, body)
+	if err != nil {
+		// unblocks the goroutine that writes the form
+		body.Close()
+		return
+	}
+
+	req.ContentLength = length
+	req.GetBody = func() (io.ReadCloser, error) {
+		return withProgress(streamForm(boundary, files, fields),
+			length, progress), nil
+	}
+
+	// set type & boundary
+	req.Header.Set("Content-Type",
+		"multipart/form-data; boundary="+boundary)
+	return
+}
+
+// newDataRequest creates a request whose body is data
+func newDataRequest(method, url, contentType string, data []byte,
+	progress ProgressFunc) (req *http.Request, err error) {
+
+	body := func() io.ReadCloser {
+		return withProgress(ioutil.NopCloser(bytes.NewReader(data)),
+			int64(len(data)), progress)
+	}
+
+	req, err = http.NewRequest(method, url, body())
+	if err != nil {
+		return
+	}
+
+	req.ContentLength = int64(len(data))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return body(), nil
+	}
+	req.Header.Set("Content-Type", contentType)
+	return
+}
+
 // SendRequest prepares HTTP request and sends it
 //
 // if fileParamName empty, no file field will be created and
 // filePath is ignored
+// extraFiles maps form field names to paths of additional files that are
+// sent as file fields of the multi-part form
 // if username is empty, no http auth header will be sent
 //
-// if method is GET or PUT, the parameters will be url-encoded, otherwise they
-// will be fields of the multi-part form
+// bodyType is one of the Body* constants and selects how the request body
+// is built:
 //
-// if method is PUT and filePath is set, the request body will be the contents
-// of the file
+//	MultipartFormData: the file, extraFiles and extraParams as a multi-part
+//	                   form
+//	FormURLEncoded: extraParams url-encoded
+//	JSON, XML: data as is
+//	Binary: the contents of the file at filePath
+//	None: no body
+//
+// for all body types but MultipartFormData and FormURLEncoded, extraParams
+// are added to the query string of the url.
+//
+// if bodyType is empty, the body depends on the method: if method is GET or
+// PUT, the parameters will be url-encoded, otherwise they will be fields of
+// the multi-part form. if method is PUT and filePath is set, the request
+// body will be the contents of the file
+//
+// if progress is not nil, it is called as the request body is sent
+//
+// failed attempts are retried as specified by policy. if policy is nil, the
+// request is sent once with no timeout. errors are returned as
+// *RequestError. if all attempts fail because of the response status, a
+// *RequestError is returned along with the last response only if policy
+// allows retries, otherwise the response is returned as is
 func SendRequest(method, url, fileParamName, filePath string,
-	extraParams map[string]string, extraHeaders map[string]string,
-	username string, password string,
-) (res *http.Response, filename string, err error) {
+	extraFiles map[string]string, extraParams map[string]string,
+	bodyType, data string, extraHeaders map[string]string, username string,
+	password string, progress ProgressFunc, policy *RetryPolicy) (
+	res *Response, filename string, err error) {
 
-	if method == "GET" || method == "PUT" {
+	if policy == nil {
+		policy = &RetryPolicy{}
+	}
+	if err = policy.validate(); err != nil {
+		return
+	}
+
+	legacy := bodyType == ""
+	if legacy && method == "PUT" && filePath != "" {
+		bodyType = BodyBinary
+	}
+
+	paramsInQuery := method == "GET" || method == "PUT"
+	switch bodyType {
+	case "":
+	case BodyMultipart, BodyFormURLEncoded:
+		paramsInQuery = false
+	default:
+		paramsInQuery = true
+	}
+
+	if paramsInQuery {
 		var u *neturl.URL
 		u, err = neturl.Parse(url)
 		if err != nil {
@@ -88,85 +499,70 @@ func SendRequest(method, url, fileParamName, filePath string,
 		url = u.String()
 	}
 
-	// prepare request body buffer
-	buf := &bytes.Buffer{}
-
-	w := multipart.NewWriter(buf)
+	// finally create the request. the body is streamed from the files with
+	// a known length rather than buffered in memory
+	var req *http.Request
+	switch bodyType {
+	case "", BodyMultipart:
+		// legacy PUT requests without a file send an empty form
+		sendFiles := !legacy || method != "PUT"
 
-	if fileParamName != "" && method != "PUT" {
-		filename = filepath.Base(filePath)
+		var files []formFile
+		if fileParamName != "" && sendFiles {
+			filename = filepath.Base(filePath)
+			files = append(files, formFile{fileParamName, filePath})
+		}
 
-		var realmime string
-		realmime, err = SniffMimeType(filePath)
-		if err != nil {
-			return
+		// additional files such as thumbnails
+		if sendFiles {
+			params := make([]string, 0, len(extraFiles))
+			for param := range extraFiles {
+				params = append(params, param)
+			}
+			sort.Strings(params)
+			for _, param := range params {
+				files = append(files, formFile{param, extraFiles[param]})
+			}
 		}
 
-		// try opening the file
-		var file *os.File
-		file, err = os.Open(filePath)
-		if err != nil {
-			return
+		// append extra params as form fields
+		var fields map[string]string
+		if !paramsInQuery {
+			fields = extraParams
 		}
-		defer file.Close()
 
-		// create a multipart file header with the given param name and file
-		h := make(textproto.MIMEHeader)
-		h.Set("Content-Disposition",
-			fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
-				escapeQuotes(fileParamName), escapeQuotes(filename)))
-		h.Set("Content-Type", realmime)
+		req, err = newFormRequest(method, url, files, fields, progress)
 
-		var formfile io.Writer
-		formfile, err = w.CreatePart(h)
-		if err != nil {
+	case BodyBinary:
+		if filePath == "" {
+			err = errors.New("Binary request body requires a file")
 			return
 		}
+		filename = filepath.Base(filePath)
+		req, err = newFileRequest(method, url, filePath, progress)
 
-		// write the file to the file header
-		_, err = io.Copy(formfile, file)
-	}
-
-	// append extra params as form fields
-	if method != "GET" && method != "PUT" {
-		for param, val := range extraParams {
-			err = w.WriteField(param, val)
-			if err != nil {
-				return
-			}
+	case BodyFormURLEncoded:
+		values := make(neturl.Values, len(extraParams))
+		for name, value := range extraParams {
+			values.Set(name, value)
 		}
-	}
+		req, err = newDataRequest(method, url,
+			"application/x-www-form-urlencoded", []byte(values.Encode()),
+			progress)
 
-	ctype := w.FormDataContentType()
+	case BodyJSON:
+		req, err = newDataRequest(method, url, "application/json",
+			[]byte(data), progress)
 
-	err = w.Close()
-	if err != nil {
-		return
-	}
+	case BodyXML:
+		req, err = newDataRequest(method, url, "application/xml",
+			[]byte(data), progress)
 
-	// finally create the request
-	var req *http.Request
-	if method == "PUT" && filePath != "" {
-		var freader *os.File
-		freader, err = os.Open(filePath)
-		defer freader.Close()
-		if err != nil {
-			return
-		}
-		req, err = http.NewRequest(method, url, freader)
+	case BodyNone:
+		req, err = http.NewRequest(method, url, nil)
 
-		var realmime string
-		realmime, err = SniffMimeType(filePath)
-		if err != nil {
-			return
-		}
-
-		req.Header.Set("Content-Type", realmime)
-	} else {
-		req, err = http.NewRequest(method, url, buf)
-
-		// set type & boundary
-		req.Header.Set("Content-Type", ctype)
+	default:
+		err = fmt.Errorf("Invalid request body type: %s", bodyType)
 	}
 
 	if err != nil {
@@ -185,16 +581,72 @@ func SendRequest(method, url, fileParamName, filePath string,
 	}
 
 	// send request
-	client := &http.Client{}
+	client := &http.Client{Timeout: policy.Timeout}
 	requestDump, err := httputil.DumpRequest(req, false)
 	if err != nil {
 		DebugPrintln(err)
 	} else {
 		DebugPrintln(fmt.Sprintf("%q", requestDump))
 	}
-	res, err = client.Do(req)
+
+	var hres *http.Response
+	attempt := 1
+	for ; ; attempt++ {
+		hres, err = client.Do(req)
+		if attempt > policy.Retries || !policy.failed(hres, err) {
+			break
+		}
+
+		if err != nil {
+			DebugPrintln("Attempt", attempt, "failed:", err)
+		} else {
+			DebugPrintln("Attempt", attempt, "failed:", hres.Status)
+			hres.Body.Close()
+		}
+
+		delay := backoff(attempt)
+		DebugPrintln("Retrying in", delay)
+		time.Sleep(delay)
+
+		// the body was consumed by the previous attempt. requests without a
+		// body have no GetBody
+		req = req.Clone(req.Context())
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return
+			}
+		}
+	}
+
+	// the query is left out of the error as it can contain api keys
+	reqerr := &RequestError{Method: method, URL: req.URL.Scheme + "://" +
+		req.URL.Host + req.URL.Path, Attempts: attempt}
+
+	if err == nil && policy.Retries > 0 && policy.failed(hres, nil) {
+		// the last response is returned too, as it usually says why the
+		// request failed
+		reqerr.StatusCode = hres.StatusCode
+		if res, err = readResponse(hres); err == nil {
+			err = reqerr
+			return
+		}
+	}
+
+	if err == nil {
+		// the body is read here so that the timeout applies to it too
+		res, err = readResponse(hres)
+	}
+
 	if err != nil {
-		panic(err)
+		reqerr.Timeout = isTimeout(err)
+		// the url error repeats the full url
+		var urlerr *neturl.Error
+		if errors.As(err, &urlerr) {
+			err = urlerr.Err
+		}
+		reqerr.Err = err
+		err = reqerr
 	}
+
 	return
 }
@@ -0,0 +1,419 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sparseFile creates a file of the given size that takes no space on disk
+func sparseFile(t *testing.T, size int64) string {
+	p := path.Join(t.TempDir(), "video.webm")
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err = f.Truncate(size); err != nil {
+		t.Skip("can't create a sparse file:", err)
+	}
+	return p
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (n int, err error) {
+	n, err = c.r.Read(p)
+	c.n += int64(n)
+	return
+}
+
+func TestSendRequestStreaming(t *testing.T) {
+	const size = 512 << 20
+	file := sparseFile(t, size)
+
+	// the server reports the Content-Length it got, the length of the body
+	// and the size of the file it found in it
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			var filesize int64
+			cr := &countingReader{r: r.Body}
+			if r.Method == "POST" {
+				r.Body = ioutil.NopCloser(cr)
+				mr, err := r.MultipartReader()
+				if err != nil {
+					http.Error(w, err.Error(), 400)
+					return
+				}
+				for {
+					part, err := mr.NextPart()
+					if err == io.EOF {
+						break
+					}
+					if err != nil {
+						http.Error(w, err.Error(), 400)
+						return
+					}
+					n, _ := io.Copy(ioutil.Discard, part)
+					if part.FormName() == "file" {
+						filesize = n
+					}
+				}
+			} else {
+				filesize, _ = io.Copy(ioutil.Discard, cr)
+			}
+			io.Copy(ioutil.Discard, cr)
+			fmt.Fprintf(w, "%d %d %d", r.ContentLength, cr.n, filesize)
+		}))
+	defer srv.Close()
+
+	for _, method := range []string{"POST", "PUT"} {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+
+		res, _, err := SendRequest(method, srv.URL, "file", file, nil,
+			map[string]string{"key": "value"}, "", "", nil, "", "", nil,
+			nil)
+		if err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+
+		runtime.ReadMemStats(&after)
+		if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 64<<20 {
+			t.Errorf("%s: allocated %d MiB to upload %d MiB", method,
+				alloc>>20, size>>20)
+		}
+
+		var length, body, filesize int64
+		_, err = fmt.Sscanf(string(res.Body), "%d %d %d", &length, &body,
+			&filesize)
+		if err != nil {
+			t.Fatalf("%s: bad response %q", method, res.Body)
+		}
+		if length != body {
+			t.Errorf("%s: Content-Length is %d, the body is %d bytes",
+				method, length, body)
+		}
+		if filesize != size {
+			t.Errorf("%s: the server got %d bytes of the file, want %d",
+				method, filesize, size)
+		}
+	}
+}
+
+// an intermittentServer fails the first requests it gets with 503 and records
+// the body of every request
+type intermittentServer struct {
+	*httptest.Server
+	failures int
+
+	mutex  sync.Mutex
+	bodies [][]byte
+}
+
+func newIntermittentServer(t *testing.T, failures int) *intermittentServer {
+	srv := &intermittentServer{failures: failures}
+	srv.Server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil || int64(len(body)) != r.ContentLength &&
+				r.ContentLength != -1 {
+
+				http.Error(w, "bad body", 400)
+				return
+			}
+
+			srv.mutex.Lock()
+			srv.bodies = append(srv.bodies, body)
+			attempt := len(srv.bodies)
+			srv.mutex.Unlock()
+
+			if attempt <= srv.failures {
+				http.Error(w, "try again", 503)
+				return
+			}
+			w.Write(body)
+		}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// withShortRetries makes retries wait a millisecond for the rest of the test
+func withShortRetries(t *testing.T) {
+	base, max := retryBaseDelay, maxRetryDelay
+	retryBaseDelay, maxRetryDelay = time.Millisecond, time.Millisecond
+	t.Cleanup(func() {
+		retryBaseDelay, maxRetryDelay = base, max
+	})
+}
+
+func TestSendRequestRetries(t *testing.T) {
+	withShortRetries(t)
+	file := path.Join(t.TempDir(), "a.txt")
+	if err := ioutil.WriteFile(file, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	params := map[string]string{"a": "1"}
+	policy := &RetryPolicy{Retries: 2}
+
+	for _, bodyType := range []string{BodyNone, BodyMultipart,
+		BodyFormURLEncoded, BodyJSON, BodyXML, BodyBinary} {
+
+		srv := newIntermittentServer(t, 2)
+		res, _, err := SendRequest("POST", srv.URL, "file", file, nil,
+			params, bodyType, `{"b": 2}`, nil, "", "", nil, policy)
+		if err != nil {
+			t.Errorf("%s: %v", bodyType, err)
+			continue
+		}
+
+		if len(srv.bodies) != 3 {
+			t.Errorf("%s: the server got %d requests, want 3", bodyType,
+				len(srv.bodies))
+			continue
+		}
+		for i, body := range srv.bodies {
+			if !bytes.Equal(body, res.Body) {
+				t.Errorf("%s: attempt %d sent %q, the last one %q", bodyType,
+					i+1, body, res.Body)
+			}
+		}
+		if bodyType != BodyNone && len(res.Body) == 0 {
+			t.Errorf("%s: the body is empty", bodyType)
+		}
+	}
+}
+
+func TestSendRequestRetriesExhausted(t *testing.T) {
+	withShortRetries(t)
+	srv := newIntermittentServer(t, 5)
+
+	res, _, err := SendRequest("GET", srv.URL+"/upload?key=secret", "", "",
+		nil, nil, BodyNone, "", nil, "", "", nil, &RetryPolicy{Retries: 2})
+
+	var reqerr *RequestError
+	if !errors.As(err, &reqerr) {
+		t.Fatalf("got %v, want a RequestError", err)
+	}
+	if res == nil || res.StatusCode != 503 ||
+		strings.TrimSpace(string(res.Body)) != "try again" {
+
+		t.Errorf("got response %+v, want the last one", res)
+	}
+	if reqerr.Attempts != 3 || reqerr.StatusCode != 503 {
+		t.Errorf("got %d attempts and status %d, want 3 and 503",
+			reqerr.Attempts, reqerr.StatusCode)
+	}
+	if reqerr.URL != srv.URL+"/upload" {
+		t.Errorf("the error has url %s, want it without the query",
+			reqerr.URL)
+	}
+}
+
+func TestRequestFailureMessage(t *testing.T) {
+	withStorage(t)
+	withShortRetries(t)
+	srv := newIntermittentServer(t, 5)
+
+	sitecfg := &SiteConfig{ErrorMessage: "{status}: {response}"}
+	res, name, err := SendRequest("GET", srv.URL, "", "", nil, nil,
+		BodyNone, "", nil, "", "", nil, &RetryPolicy{Retries: 1})
+	err = requestFailure(sitecfg, res, name, err)
+
+	var uploaderr *UploadError
+	if !errors.As(err, &uploaderr) {
+		t.Fatalf("got %v, want an UploadError", err)
+	}
+	if strings.TrimSpace(uploaderr.Message) != "503: try again" {
+		t.Errorf("got message %q, want the one from the response",
+			uploaderr.Message)
+	}
+
+	// network errors have no response to explain them
+	neterr := &RequestError{Method: "GET", URL: srv.URL, Attempts: 2}
+	if err = requestFailure(sitecfg, nil, "", neterr); err != neterr {
+		t.Errorf("got %v, want the RequestError", err)
+	}
+}
+
+func TestSendRequestBodies(t *testing.T) {
+	var got struct {
+		contentType, query string
+		body               []byte
+	}
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			got.contentType = r.Header.Get("Content-Type")
+			got.query = r.URL.RawQuery
+			got.body, _ = ioutil.ReadAll(r.Body)
+		}))
+	defer srv.Close()
+
+	file := path.Join(t.TempDir(), "a.txt")
+	if err := ioutil.WriteFile(file, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	params := map[string]string{"b": "x y", "a": "1"}
+	data := `{"c": "<d>"}`
+
+	tests := []struct {
+		bodyType, contentType, query, body string
+	}{
+		{BodyNone, "", "a=1&b=x+y", ""},
+		{BodyFormURLEncoded, "application/x-www-form-urlencoded", "",
+			"a=1&b=x+y"},
+		{BodyJSON, "application/json", "a=1&b=x+y", data},
+		{BodyXML, "application/xml", "a=1&b=x+y", data},
+		{BodyBinary, "text/plain; charset=utf-8", "a=1&b=x+y", "hello"},
+		{BodyMultipart, "multipart/form-data", "",
+			"--B\r\n" +
+				`Content-Disposition: form-data; name="file"; ` +
+				`filename="a.txt"` + "\r\n" +
+				"Content-Type: text/plain; charset=utf-8\r\n\r\n" +
+				"hello\r\n" +
+				"--B\r\n" +
+				`Content-Disposition: form-data; name="a"` + "\r\n\r\n" +
+				"1\r\n" +
+				"--B\r\n" +
+				`Content-Disposition: form-data; name="b"` + "\r\n\r\n" +
+				"x y\r\n" +
+				"--B--\r\n"},
+	}
+
+	for _, test := range tests {
+		_, _, err := SendRequest("POST", srv.URL, "file", file, nil, params,
+			test.bodyType, data, nil, "", "", nil, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.bodyType, err)
+			continue
+		}
+
+		// the multipart boundary is random
+		want := test.body
+		mediaType, mparams, _ := mime.ParseMediaType(got.contentType)
+		if boundary := mparams["boundary"]; boundary != "" {
+			want = strings.Replace(want, "--B", "--"+boundary, -1)
+			got.contentType = mediaType
+		}
+
+		if got.contentType != test.contentType {
+			t.Errorf("%s: Content-Type is %q, want %q", test.bodyType,
+				got.contentType, test.contentType)
+		}
+		if got.query != test.query {
+			t.Errorf("%s: the query is %q, want %q", test.bodyType,
+				got.query, test.query)
+		}
+		if string(got.body) != want {
+			t.Errorf("%s: the body is\n%q\nwant\n%q", test.bodyType,
+				got.body, want)
+		}
+	}
+
+	_, _, err := SendRequest("POST", srv.URL, "", "", nil, nil, BodyBinary,
+		"", nil, "", "", nil, nil)
+	if err == nil {
+		t.Error("a Binary body without a file didn't fail")
+	}
+	_, _, err = SendRequest("POST", srv.URL, "", "", nil, nil, "Yaml", "",
+		nil, "", "", nil, nil)
+	if err == nil {
+		t.Error("an unknown body type didn't fail")
+	}
+}
+
+func TestSendRequestResponse(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/done", http.StatusSeeOther)
+	})
+	mux.HandleFunc("/done", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Id", "abc")
+		w.Header().Set("Location", "/f/abc")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("https://example.com/f/abc"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	res, _, err := SendRequest("GET", srv.URL+"/upload", "", "", nil, nil,
+		BodyNone, "", nil, "", "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.StatusCode != 201 || res.Status() != "201 Created" {
+		t.Errorf("the status is %d, %q", res.StatusCode, res.Status())
+	}
+	if res.Header.Get("X-Id") != "abc" {
+		t.Errorf("the headers are %v", res.Header)
+	}
+	if string(res.Body) != "https://example.com/f/abc" {
+		t.Errorf("the body is %q", res.Body)
+	}
+	if res.URL != srv.URL+"/done" {
+		t.Errorf("the url is %s, want the one after the redirect", res.URL)
+	}
+
+	location, err := res.Location()
+	if err != nil || location.String() != srv.URL+"/f/abc" {
+		t.Errorf("Location() = %v, %v", location, err)
+	}
+}
+
+func TestSendRequestBodyTimeout(t *testing.T) {
+	// the headers arrive right away, the body never finishes
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("https://"))
+			w.(http.Flusher).Flush()
+			<-release
+		}))
+	defer srv.Close()
+	defer close(release)
+
+	start := time.Now()
+	_, _, err := SendRequest("GET", srv.URL, "", "", nil, nil, BodyNone, "",
+		nil, "", "", nil, &RetryPolicy{Timeout: 100 * time.Millisecond})
+
+	var reqerr *RequestError
+	if !errors.As(err, &reqerr) || !reqerr.Timeout {
+		t.Fatalf("got %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the request took %v", elapsed)
+	}
+}
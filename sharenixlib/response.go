@@ -0,0 +1,74 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// A Response is the response to an upload, sent either by a server or by a
+// plugin
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	// URL is the url of the response after redirects, empty for plugins
+	URL string
+
+	// the result of json plugins
+	plugin *PluginResult
+}
+
+// readResponse reads the body of res and closes it
+func readResponse(res *http.Response) (resp *Response, err error) {
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return
+	}
+
+	resp = &Response{
+		StatusCode: res.StatusCode,
+		Header:     res.Header,
+		Body:       body,
+		URL:        res.Request.URL.String(),
+	}
+	return
+}
+
+// Status returns the status code followed by its text, such as "200 OK"
+func (r *Response) Status() string {
+	return fmt.Sprintf("%d %s", r.StatusCode, http.StatusText(r.StatusCode))
+}
+
+// Location returns the url in the Location header, resolved relative to the
+// url of the response
+func (r *Response) Location() (*url.URL, error) {
+	location := r.Header.Get("Location")
+	if location == "" {
+		return nil, http.ErrNoLocation
+	}
+
+	base, err := url.Parse(r.URL)
+	if err != nil {
+		return nil, err
+	}
+	return base.Parse(location)
+}
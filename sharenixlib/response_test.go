@@ -0,0 +1,255 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// testResponse is a response to an upload to https://example.com/upload
+func testResponse(status int, body string, header ...string) *Response {
+	res := &Response{
+		StatusCode: status,
+		Header:     make(http.Header),
+		Body:       []byte(body),
+		URL:        "https://example.com/upload",
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		res.Header.Set(header[i], header[i+1])
+	}
+	return res
+}
+
+func TestParseResponseHeaders(t *testing.T) {
+	withStorage(t)
+
+	tests := []struct {
+		name    string
+		sitecfg SiteConfig
+		res     *Response
+		url     string
+		delete  string
+	}{
+		{"location", SiteConfig{ResponseType: "Headers"},
+			testResponse(201, "https://example.com/body", "Location",
+				"/f/abc.png"),
+			"https://example.com/f/abc.png", ""},
+
+		{"absolute location", SiteConfig{ResponseType: "Headers"},
+			testResponse(201, "", "Location", "https://cdn.example.com/a"),
+			"https://cdn.example.com/a", ""},
+
+		{"header keywords", SiteConfig{ResponseType: "Headers",
+			URL:         "$header:X-File-Url$",
+			DeletionURL: "https://example.com/delete/{header:x-delete-key}",
+		}, testResponse(200, "", "X-File-Url", "https://example.com/a",
+			"X-Delete-Key", "k3y"),
+			"https://example.com/a", "https://example.com/delete/k3y"},
+
+		{"status", SiteConfig{URL: "https://example.com/$status$/$1$",
+			RegexList: []string{`\w+$`}},
+			testResponse(202, "id=abc"),
+			"https://example.com/202/abc", ""},
+
+		{"status keyword", SiteConfig{URL: "https://example.com/{status}"},
+			testResponse(201, ""),
+			"https://example.com/201", ""},
+
+		{"response url", SiteConfig{URL: "$responseurl$?raw=1"},
+			testResponse(200, "ok"),
+			"https://example.com/upload?raw=1", ""},
+
+		{"redirection url", SiteConfig{ResponseType: "RedirectionURL"},
+			testResponse(200, "ok"),
+			"https://example.com/upload", ""},
+	}
+
+	for _, test := range tests {
+		url, _, deleteurl, err := ParseResponse(&test.sitecfg, test.res,
+			"a.png")
+		if err != nil || url != test.url || deleteurl != test.delete {
+			t.Errorf("%s: got %q, %q, %v, want %q, %q", test.name, url,
+				deleteurl, err, test.url, test.delete)
+		}
+	}
+}
+
+func TestParseResponseHeadersIgnoreBody(t *testing.T) {
+	withStorage(t)
+
+	// the body isn't used in Headers mode, not even by keywords
+	sitecfg := &SiteConfig{ResponseType: "Headers", URL: "$json:url$"}
+	res := testResponse(201, `{"url": "https://example.com/body"}`,
+		"Location", "/a")
+	if url, _, _, err := ParseResponse(sitecfg, res, "a.png"); err == nil {
+		t.Errorf("got %q, want an error", url)
+	}
+
+	sitecfg = &SiteConfig{ResponseType: "Headers"}
+	res = testResponse(201, "https://example.com/body")
+	if url, _, _, err := ParseResponse(sitecfg, res, "a.png"); err == nil {
+		t.Errorf("no Location header: got %q, want an error", url)
+	}
+}
+
+func TestImportSXCULocationHeader(t *testing.T) {
+	sitecfg, _, _, err := ImportSXCU([]byte(`{
+		"Name": "example.com",
+		"RequestType": "POST",
+		"RequestURL": "https://example.com/upload",
+		"FileFormName": "file",
+		"ResponseType": "LocationHeader"
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sitecfg.ResponseType != "Headers" || sitecfg.URL != "" {
+		t.Errorf("got ResponseType %q and URL %q, want Headers and none",
+			sitecfg.ResponseType, sitecfg.URL)
+	}
+}
+
+func TestParseResponseSuccess(t *testing.T) {
+	withStorage(t)
+
+	tests := []struct {
+		name    string
+		sitecfg SiteConfig
+		res     *Response
+		ok      bool
+	}{
+		{"default 2xx", SiteConfig{}, testResponse(201, "https://x.io/a"),
+			true},
+		{"default 2xx", SiteConfig{}, testResponse(404, "https://x.io/a"),
+			false},
+		{"exact status", SiteConfig{SuccessStatus: []string{"201"}},
+			testResponse(200, "https://x.io/a"), false},
+		{"status class", SiteConfig{SuccessStatus: []string{"201", "3xx"}},
+			testResponse(302, "https://x.io/a"), true},
+		{"condition", SiteConfig{SuccessCondition: "$json:success$",
+			URL: "$json:url$"},
+			testResponse(200, `{"success": true, "url": "https://x.io/a"}`),
+			true},
+		{"condition false", SiteConfig{SuccessCondition: "{json:success}"},
+			testResponse(200, `{"success": false}`), false},
+		{"condition 0", SiteConfig{SuccessCondition: "{json:success}"},
+			testResponse(200, `{"success": 0}`), false},
+		{"condition null", SiteConfig{SuccessCondition: "{json:success}"},
+			testResponse(200, `{"success": null}`), false},
+		{"condition empty", SiteConfig{SuccessCondition: "{json:success}"},
+			testResponse(200, `{"success": ""}`), false},
+		{"comparison", SiteConfig{
+			SuccessCondition: "{json:status} == ok", URL: "$json:url$"},
+			testResponse(200, `{"status": "ok", "url": "https://x.io/a"}`),
+			true},
+		{"comparison", SiteConfig{
+			SuccessCondition: "{json:status} == ok", URL: "$json:url$"},
+			testResponse(200, `{"status": "no", "url": "https://x.io/a"}`),
+			false},
+		{"negated comparison", SiteConfig{
+			SuccessCondition: "$status$ != 299"},
+			testResponse(299, "https://x.io/a"), false},
+		{"any scheme", SiteConfig{}, testResponse(200, "ipfs://Qm1234\n"),
+			true},
+		{"magnet", SiteConfig{},
+			testResponse(200, "magnet:?xt=urn:btih:1234"), true},
+		{"not only a url", SiteConfig{},
+			testResponse(200, "your file: https://x.io/a"), false},
+	}
+
+	for _, test := range tests {
+		url, _, _, err := ParseResponse(&test.sitecfg, test.res, "a.png")
+		var uploaderr *UploadError
+		switch {
+		case test.ok && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case !test.ok && !errors.As(err, &uploaderr):
+			t.Errorf("%s: got %q, %v, want an UploadError", test.name, url,
+				err)
+		}
+	}
+
+	// invalid settings are errors, not failed uploads
+	_, _, _, err := ParseResponse(&SiteConfig{SuccessStatus: []string{"6xx"}},
+		testResponse(200, "https://x.io/a"), "a.png")
+	var uploaderr *UploadError
+	if err == nil || errors.As(err, &uploaderr) {
+		t.Errorf("invalid SuccessStatus: got %v", err)
+	}
+}
+
+func TestParseResponseErrorMessage(t *testing.T) {
+	withStorage(t)
+
+	long := strings.Repeat("x", maxResponseErrorLength+50)
+	tests := []struct {
+		name         string
+		errorMessage string
+		res          *Response
+		want         string
+	}{
+		{"template", "{json:error.message}", testResponse(413,
+			`{"error": {"message": "File too large"}}`), "File too large"},
+		{"legacy template", "$json:error$ ($status$)", testResponse(400,
+			`{"error": "Bad file"}`), "Bad file (400)"},
+		{"fallback", "", testResponse(500, "  Internal error\n"),
+			"Internal error"},
+		{"empty template", "{json:error}", testResponse(500,
+			`{"error": ""}`), `{"error": ""}`},
+		{"broken template", "{json:error}", testResponse(502, "<html>"),
+			"<html>"},
+		{"long response", "", testResponse(500, long),
+			long[:maxResponseErrorLength] + "..."},
+	}
+
+	for _, test := range tests {
+		sitecfg := &SiteConfig{ErrorMessage: test.errorMessage}
+		_, _, _, err := ParseResponse(sitecfg, test.res, test.name)
+
+		var uploaderr *UploadError
+		if !errors.As(err, &uploaderr) {
+			t.Errorf("%s: got %v, want an UploadError", test.name, err)
+			continue
+		}
+		if uploaderr.Message != test.want ||
+			uploaderr.StatusCode != test.res.StatusCode {
+
+			t.Errorf("%s: got %q and status %d, want %q and %d", test.name,
+				uploaderr.Message, uploaderr.StatusCode, test.want,
+				test.res.StatusCode)
+		}
+	}
+
+	// failed uploads are in the history with their message
+	history, err := GetUploadHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != len(tests) {
+		t.Fatalf("got %d history records, want %d", len(history),
+			len(tests))
+	}
+	for i, record := range history {
+		if len(record) != 5 || record[0] != "" ||
+			record[3] != tests[i].name || record[4] != tests[i].want {
+
+			t.Errorf("history record %d is %q", i, record)
+		}
+	}
+}
@@ -17,12 +17,14 @@ package sharenixlib
 
 import (
 	"errors"
+	"fmt"
 	"github.com/BurntSushi/xgb"
 	"github.com/BurntSushi/xgb/xinerama"
 	"github.com/BurntSushi/xgb/xproto"
 	"image"
 	"image/draw"
-	"sort"
+	"regexp"
+	"strconv"
 )
 
 // This file is heavily inspired by https://github.com/vova616/screenshot
@@ -37,6 +39,21 @@ type ScreenRect struct {
 	ScreenIndex int
 }
 
+// RootRect returns the bounds of the screen relative to its root window.
+// This is the same as Rect for xinerama heads, while screens that have their
+// own root window always start at 0, 0.
+func (r *ScreenRect) RootRect() image.Rectangle {
+	if r.ScreenIndex == -1 {
+		return r.Rect
+	}
+	return r.Rect.Sub(r.Rect.Min)
+}
+
+// Capture captures the whole screen. Returns an uncompressed image.
+func (r *ScreenRect) Capture(X *xgb.Conn) (*image.RGBA, error) {
+	return CaptureRect(X, r.ScreenIndex, r.RootRect())
+}
+
 // ByX is a sorter for a slice of ScreenRect pointers.
 type ByX []*ScreenRect
 
@@ -92,18 +109,134 @@ func ScreenRects(X *xgb.Conn) (rects []*ScreenRect, err error) {
 		return
 	}
 
-	// no multiple xinerama heads,
+	// no multiple xinerama heads, each screen has its own root window.
+	// there's no way to know how they are laid out so we line them up
+	// horizontally
 	DebugPrintln("Using", len(setupInfo.Roots), "screens")
 	x := 0
 	for i, s := range setupInfo.Roots {
 		DebugPrintf("%d\tX: %d\tY: 0\tWidth: %d\tHeight: %d\n",
 			i, x, s.WidthInPixels, s.HeightInPixels)
 		rects = append(rects, &ScreenRect{
-			image.Rect(0, 0, int(s.WidthInPixels), int(s.HeightInPixels)), i,
+			image.Rect(x, 0, x+int(s.WidthInPixels), int(s.HeightInPixels)), i,
 		})
-		x += x + int(s.WidthInPixels)
+		x += int(s.WidthInPixels)
+	}
+
+	return
+}
+
+var geometryRegexp = regexp.MustCompile(`^(\d+)x(\d+)(?:\+(\d+)\+(\d+))?$`)
+
+// ParseGeometry parses a X11-style WxH+X+Y geometry string.
+// The offset can be omitted, in which case it defaults to +0+0.
+func ParseGeometry(geometry string) (rect image.Rectangle, err error) {
+	m := geometryRegexp.FindStringSubmatch(geometry)
+	if m == nil {
+		err = fmt.Errorf("Invalid geometry: %s (expected WxH+X+Y)", geometry)
+		return
+	}
+
+	var w, h, x, y int
+	w, _ = strconv.Atoi(m[1])
+	h, _ = strconv.Atoi(m[2])
+	if m[3] != "" {
+		x, _ = strconv.Atoi(m[3])
+		y, _ = strconv.Atoi(m[4])
+	}
+
+	if w == 0 || h == 0 {
+		err = fmt.Errorf("Invalid geometry: %s (empty area)", geometry)
+		return
 	}
 
+	rect = image.Rect(x, y, x+w, y+h)
+	return
+}
+
+// screenRoot returns the root window of the screen a ScreenRect belongs to
+func screenRoot(X *xgb.Conn, r *ScreenRect) (root xproto.Window, err error) {
+	setupInfo := xproto.Setup(X)
+	if setupInfo == nil {
+		err = errors.New("Failed to retrieve X setup info!")
+		return
+	}
+
+	if r.ScreenIndex == -1 {
+		screen := setupInfo.DefaultScreen(X)
+		if screen == nil {
+			err = errors.New("No default screen found")
+			return
+		}
+		root = screen.Root
+	} else {
+		root = setupInfo.Roots[r.ScreenIndex].Root
+	}
+	return
+}
+
+// PointerHead returns the index of the monitor that contains the mouse
+// pointer in the slice returned by ScreenRects
+func PointerHead(X *xgb.Conn, rects []*ScreenRect) (head int, err error) {
+	for i, r := range rects {
+		var root xproto.Window
+		root, err = screenRoot(X, r)
+		if err != nil {
+			return
+		}
+
+		var reply *xproto.QueryPointerReply
+		reply, err = xproto.QueryPointer(X, root).Reply()
+		if err != nil {
+			return
+		}
+
+		pt := image.Pt(int(reply.RootX), int(reply.RootY))
+		if reply.SameScreen && pt.In(r.RootRect()) {
+			DebugPrintln("Pointer is at", pt, "on head", i)
+			return i, nil
+		}
+	}
+
+	err = errors.New("Could not find the monitor under the pointer")
+	return
+}
+
+// FindHead returns the bounds of a single monitor.
+// head: the index of the monitor, "pointer" for the monitor under the
+//       mouse pointer or "default" for defaultHead
+func FindHead(X *xgb.Conn, head string, defaultHead uint32) (
+	rect *ScreenRect, err error) {
+
+	rects, err := ScreenRects(X)
+	if err != nil {
+		return
+	}
+
+	var i int
+	switch head {
+	case "pointer":
+		i, err = PointerHead(X, rects)
+		if err != nil {
+			return
+		}
+	case "default":
+		i = int(defaultHead)
+	default:
+		i, err = strconv.Atoi(head)
+		if err != nil {
+			err = fmt.Errorf("Invalid head: %s", head)
+			return
+		}
+	}
+
+	if i < 0 || i >= len(rects) {
+		err = fmt.Errorf("Invalid head: %d (there are %d heads)", i,
+			len(rects))
+		return
+	}
+
+	rect = rects[i]
 	return
 }
 
@@ -114,36 +247,40 @@ func CaptureScreen(X *xgb.Conn) (pic *image.RGBA, err error) {
 		return
 	}
 
-	sort.Sort(ByX(rects))
+	return composeScreens(rects, func(r *ScreenRect) (*image.RGBA, error) {
+		return r.Capture(X)
+	})
+}
+
+// composeScreens captures each screen with capture and draws it at its
+// position in the bounding box of all screens. areas that aren't covered
+// by any screen are left transparent.
+func composeScreens(rects []*ScreenRect,
+	capture func(r *ScreenRect) (*image.RGBA, error)) (
+	pic *image.RGBA, err error) {
 
 	// iterate all screens and screenshot them individually. this is necessary
 	// even on xinerama setups because otherwise different height monitors would
 	// cause garbage image data in the empty areas
 
-	totalWidth := 0
-	totalHeight := 0
+	var bounds image.Rectangle
 	for _, r := range rects {
-		totalWidth += r.Rect.Dx()
-		if r.Rect.Dy() > totalHeight {
-			totalHeight = r.Rect.Dy()
-		}
+		bounds = bounds.Union(r.Rect)
 	}
 
-	DebugPrintln("Building", totalWidth, "x", totalHeight, "image")
-	pic = image.NewRGBA(image.Rect(0, 0, totalWidth, totalHeight))
+	DebugPrintln("Building", bounds.Dx(), "x", bounds.Dy(), "image")
+	pic = image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
 	draw.Draw(pic, pic.Bounds(), image.Transparent, image.ZP, draw.Src)
 
-	x := 0
 	for _, r := range rects {
 		var screen *image.RGBA
-		screen, err = CaptureRect(X, r.ScreenIndex, r.Rect)
+		screen, err = capture(r)
 		if err != nil {
 			return
 		}
-		finalrect := screen.Bounds().Add(image.Pt(x, 0))
+		finalrect := screen.Bounds().Add(r.Rect.Min.Sub(bounds.Min))
 		draw.Draw(pic, finalrect, screen, image.ZP, draw.Src)
 		DebugPrintln("Drawing", finalrect)
-		x += r.Rect.Bounds().Dx()
 	}
 
 	return
@@ -151,6 +288,9 @@ func CaptureScreen(X *xgb.Conn) (pic *image.RGBA, err error) {
 
 // CaptureRect captures a section of the desired screen.
 // Returns an uncompressed image.
+// MIT-SHM is used when the X server supports it and is running on the same
+// machine, otherwise the image is transferred over the X connection. The
+// shared memory is kept for later captures until ReleaseShm is called.
 // screenIndex = -1 gets the default screen.
 func CaptureRect(X *xgb.Conn, screenIndex int, rect image.Rectangle) (
 	pic *image.RGBA, err error) {
@@ -173,21 +313,30 @@ func CaptureRect(X *xgb.Conn, screenIndex int, rect image.Rectangle) (
 		screen = &setupInfo.Roots[screenIndex]
 	}
 
-	// capture screen
-	xImg, err := xproto.GetImage(X, xproto.ImageFormatZPixmap,
-		xproto.Drawable(screen.Root), int16(rect.Min.X), int16(rect.Min.Y),
-		uint16(rect.Dx()), uint16(rect.Dy()), 0xFFFFFFFF).Reply()
+	format, err := newPixelFormat(setupInfo, screen, screen.RootDepth,
+		screen.RootVisual)
 	if err != nil {
 		return
 	}
 
-	// convert to rgba
-	data := xImg.Data
-	for i := 0; i < len(data); i += 4 {
-		data[i], data[i+2], data[i+3] = data[i+2], data[i], 255
+	// capture screen
+	drawable := xproto.Drawable(screen.Root)
+	data, depth, visual, err := getImageShm(X, drawable, rect,
+		format.stride(rect.Dx())*rect.Dy())
+	if err != nil {
+		data, depth, visual, err = getImage(X, drawable, rect)
+		if err != nil {
+			return
+		}
 	}
 
-	pic = &image.RGBA{data, 4 * rect.Dx(),
-		image.Rect(0, 0, rect.Dx(), rect.Dy())}
-	return
+	if depth != screen.RootDepth || visual != screen.RootVisual {
+		format, err = newPixelFormat(setupInfo, screen, depth, visual)
+		if err != nil {
+			return
+		}
+	}
+
+	// convert to rgba
+	return format.decode(data, rect.Dx(), rect.Dy())
 }
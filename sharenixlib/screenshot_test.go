@@ -0,0 +1,143 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+// headColor is the color each fake monitor is filled with
+func headColor(i int) color.RGBA {
+	return color.RGBA{uint8(40 * (i + 1)), 0, uint8(255 - 40*i), 255}
+}
+
+// composeFakeScreens runs composeScreens on monitors that are filled with
+// headColor
+func composeFakeScreens(rects []*ScreenRect) (*image.RGBA, error) {
+	index := make(map[*ScreenRect]int)
+	for i, r := range rects {
+		index[r] = i
+	}
+
+	return composeScreens(rects, func(r *ScreenRect) (*image.RGBA, error) {
+		img := image.NewRGBA(image.Rect(0, 0, r.Rect.Dx(), r.Rect.Dy()))
+		draw.Draw(img, img.Bounds(), image.NewUniform(headColor(index[r])),
+			image.ZP, draw.Src)
+		return img, nil
+	})
+}
+
+func xineramaHeads(rects ...image.Rectangle) (heads []*ScreenRect) {
+	for _, r := range rects {
+		heads = append(heads, &ScreenRect{r, -1})
+	}
+	return
+}
+
+func TestComposeScreens(t *testing.T) {
+	tests := []struct {
+		name  string
+		heads []*ScreenRect
+		size  image.Point
+	}{
+		{
+			"single",
+			xineramaHeads(image.Rect(0, 0, 64, 48)),
+			image.Pt(64, 48),
+		},
+		{
+			"side by side with different heights",
+			xineramaHeads(image.Rect(0, 0, 64, 48), image.Rect(64, 0, 96, 24)),
+			image.Pt(96, 48),
+		},
+		{
+			"stacked",
+			xineramaHeads(image.Rect(0, 0, 64, 48), image.Rect(0, 48, 64, 96)),
+			image.Pt(64, 96),
+		},
+		{
+			"staggered",
+			xineramaHeads(image.Rect(0, 20, 64, 68), image.Rect(64, 0, 128, 48),
+				image.Rect(16, 68, 48, 100)),
+			image.Pt(128, 100),
+		},
+		{
+			"not starting at the origin",
+			xineramaHeads(image.Rect(100, 50, 164, 98),
+				image.Rect(164, 60, 200, 90)),
+			image.Pt(100, 48),
+		},
+		{
+			"separate root windows",
+			[]*ScreenRect{
+				{image.Rect(0, 0, 64, 48), 0},
+				{image.Rect(64, 0, 96, 32), 1},
+			},
+			image.Pt(96, 48),
+		},
+	}
+
+	for _, test := range tests {
+		pic, err := composeFakeScreens(test.heads)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if pic.Bounds() != (image.Rectangle{Max: test.size}) {
+			t.Errorf("%s: got a %v image, want %v", test.name,
+				pic.Bounds(), test.size)
+			continue
+		}
+
+		var bounds image.Rectangle
+		for _, h := range test.heads {
+			bounds = bounds.Union(h.Rect)
+		}
+
+		// every pixel belongs to the monitor drawn there or is transparent
+		for y := 0; y < test.size.Y; y++ {
+			for x := 0; x < test.size.X; x++ {
+				want := color.RGBA{}
+				root := image.Pt(x, y).Add(bounds.Min)
+				for i, h := range test.heads {
+					if root.In(h.Rect) {
+						want = headColor(i)
+					}
+				}
+
+				if got := pic.RGBAAt(x, y); got != want {
+					t.Errorf("%s: pixel %d,%d is %v, want %v", test.name,
+						x, y, got, want)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestComposeScreensError(t *testing.T) {
+	fail := errors.New("capture failed")
+	_, err := composeScreens(xineramaHeads(image.Rect(0, 0, 8, 8)),
+		func(r *ScreenRect) (*image.RGBA, error) { return nil, fail })
+	if err != fail {
+		t.Errorf("got %v, want %v", err, fail)
+	}
+}
@@ -0,0 +1,422 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"errors"
+	"github.com/BurntSushi/xgb"
+	"github.com/BurntSushi/xgb/xproto"
+	"image"
+	"time"
+)
+
+// keysyms used by the region selector
+const (
+	keysymReturn = 0xff0d
+	keysymEscape = 0xff1b
+	keysymLeft   = 0xff51
+	keysymUp     = 0xff52
+	keysymRight  = 0xff53
+	keysymDown   = 0xff54
+)
+
+// glyphs of the standard X cursor font
+const (
+	xcCrosshair = 34
+)
+
+// how many times grabs are retried before giving up. when sharenix is started
+// from a hotkey the window manager often still holds the keyboard for a few
+// milliseconds
+const (
+	grabRetries  = 100
+	grabInterval = 10 * time.Millisecond
+)
+
+// keyMap maps keycodes to the first keysym bound to them
+type keyMap map[xproto.Keycode]xproto.Keysym
+
+func getKeyMap(X *xgb.Conn) (keys keyMap, err error) {
+	setup := xproto.Setup(X)
+	count := byte(setup.MaxKeycode - setup.MinKeycode + 1)
+	reply, err := xproto.GetKeyboardMapping(X, setup.MinKeycode, count).Reply()
+	if err != nil {
+		return
+	}
+
+	keys = make(keyMap)
+	per := int(reply.KeysymsPerKeycode)
+	for i := 0; i < int(count); i++ {
+		if i*per >= len(reply.Keysyms) {
+			break
+		}
+		keys[setup.MinKeycode+xproto.Keycode(i)] = reply.Keysyms[i*per]
+	}
+
+	return
+}
+
+func grabPointer(X *xgb.Conn, win xproto.Window, confine xproto.Window,
+	cursor xproto.Cursor) (err error) {
+
+	mask := uint16(xproto.EventMaskButtonPress |
+		xproto.EventMaskButtonRelease | xproto.EventMaskPointerMotion)
+
+	for i := 0; i < grabRetries; i++ {
+		var reply *xproto.GrabPointerReply
+		reply, err = xproto.GrabPointer(X, false, win, mask,
+			xproto.GrabModeAsync, xproto.GrabModeAsync, confine, cursor,
+			xproto.TimeCurrentTime).Reply()
+		if err != nil {
+			return
+		}
+		if reply.Status == xproto.GrabStatusSuccess {
+			return
+		}
+		time.Sleep(grabInterval)
+	}
+
+	return errors.New("Failed to grab the pointer")
+}
+
+func grabKeyboard(X *xgb.Conn, win xproto.Window) (err error) {
+	for i := 0; i < grabRetries; i++ {
+		var reply *xproto.GrabKeyboardReply
+		reply, err = xproto.GrabKeyboard(X, false, win, xproto.TimeCurrentTime,
+			xproto.GrabModeAsync, xproto.GrabModeAsync).Reply()
+		if err != nil {
+			return
+		}
+		if reply.Status == xproto.GrabStatusSuccess {
+			return
+		}
+		time.Sleep(grabInterval)
+	}
+
+	return errors.New("Failed to grab the keyboard")
+}
+
+// createCrosshair creates a crosshair cursor from the standard cursor font.
+// the cursor must be freed with xproto.FreeCursor
+func createCrosshair(X *xgb.Conn) (cursor xproto.Cursor, err error) {
+	font, err := xproto.NewFontId(X)
+	if err != nil {
+		return
+	}
+	fontName := "cursor"
+	xproto.OpenFont(X, font, uint16(len(fontName)), fontName)
+	defer xproto.CloseFont(X, font)
+
+	cursor, err = xproto.NewCursorId(X)
+	if err != nil {
+		return
+	}
+	xproto.CreateGlyphCursor(X, cursor, font, font,
+		xcCrosshair, xcCrosshair+1, 0xFFFF, 0xFFFF, 0xFFFF, 0, 0, 0)
+	return
+}
+
+// selectionRect converts the two corners of a selection to a rectangle that
+// includes both corner pixels
+func selectionRect(a, b image.Point) image.Rectangle {
+	r := image.Rectangle{a, b}.Canon()
+	r.Max = r.Max.Add(image.Pt(1, 1))
+	return r
+}
+
+// subtractRects returns the parts of r that aren't covered by any of the
+// rectangles in others as a list of non-overlapping rectangles
+func subtractRects(r image.Rectangle,
+	others []image.Rectangle) (res []image.Rectangle) {
+
+	res = []image.Rectangle{r}
+	for _, o := range others {
+		var parts []image.Rectangle
+		for _, p := range res {
+			if !p.Overlaps(o) {
+				parts = append(parts, p)
+				continue
+			}
+
+			// the strips above and below o span the whole width of p, the
+			// ones on the sides only the rows that o covers
+			in := p.Intersect(o)
+			for _, part := range []image.Rectangle{
+				image.Rect(p.Min.X, p.Min.Y, p.Max.X, in.Min.Y),
+				image.Rect(p.Min.X, in.Max.Y, p.Max.X, p.Max.Y),
+				image.Rect(p.Min.X, in.Min.Y, in.Min.X, in.Max.Y),
+				image.Rect(in.Max.X, in.Min.Y, p.Max.X, in.Max.Y),
+			} {
+				if !part.Empty() {
+					parts = append(parts, part)
+				}
+			}
+		}
+		res = parts
+	}
+	return
+}
+
+func toXRect(r image.Rectangle) xproto.Rectangle {
+	// PolyRectangle draws width+1 x height+1 outlines
+	return xproto.Rectangle{
+		X:      int16(r.Min.X),
+		Y:      int16(r.Min.Y),
+		Width:  uint16(r.Dx() - 1),
+		Height: uint16(r.Dy() - 1),
+	}
+}
+
+// SelectRegion lets the user drag a rectangle on the default screen with the
+// mouse and returns it in root window coordinates.
+// The selection is drawn as an inverted rubber-band on a transparent
+// override-redirect window that covers the screen while the pointer and
+// keyboard are grabbed.
+// Escape or right click cancel the selection and return a
+// SelectionCanceledError. Arrow keys nudge the pointer by one pixel
+// (10 pixels while holding shift) and Return confirms the current selection.
+func SelectRegion(X *xgb.Conn) (rect image.Rectangle, err error) {
+	rects, err := selectRects(X, false)
+	if err != nil {
+		return
+	}
+	rect = rects[0]
+	return
+}
+
+// SelectRedactions works like SelectRegion but lets the user drag any number
+// of boxes, which stay highlighted until Return is pressed. Right click
+// removes the last box, or cancels if there are none. The boxes are returned
+// in root window coordinates.
+func SelectRedactions(X *xgb.Conn) (rects []image.Rectangle, err error) {
+	return selectRects(X, true)
+}
+
+// selectRects implements SelectRegion and SelectRedactions. if multi is
+// false it returns as soon as the first rectangle is selected
+func selectRects(X *xgb.Conn, multi bool) (rects []image.Rectangle,
+	err error) {
+
+	setupInfo := xproto.Setup(X)
+	if setupInfo == nil {
+		err = errors.New("Failed to retrieve X setup info!")
+		return
+	}
+
+	screen := setupInfo.DefaultScreen(X)
+	if screen == nil {
+		err = errors.New("No default screen found")
+		return
+	}
+
+	keys, err := getKeyMap(X)
+	if err != nil {
+		return
+	}
+
+	cursor, err := createCrosshair(X)
+	if err != nil {
+		return
+	}
+	defer xproto.FreeCursor(X, cursor)
+
+	// a window with no background keeps showing whatever was on screen
+	// underneath it, which is exactly what we want to draw on
+	win, err := xproto.NewWindowId(X)
+	if err != nil {
+		return
+	}
+	err = xproto.CreateWindowChecked(X, screen.RootDepth, win, screen.Root,
+		0, 0, screen.WidthInPixels, screen.HeightInPixels, 0,
+		xproto.WindowClassInputOutput, screen.RootVisual,
+		xproto.CwBackPixmap|xproto.CwOverrideRedirect|xproto.CwEventMask|
+			xproto.CwCursor,
+		[]uint32{
+			xproto.BackPixmapNone,
+			1,
+			xproto.EventMaskKeyPress,
+			uint32(cursor),
+		}).Check()
+	if err != nil {
+		return
+	}
+	defer xproto.DestroyWindow(X, win)
+
+	gc, err := xproto.NewGcontextId(X)
+	if err != nil {
+		return
+	}
+	xproto.CreateGC(X, gc, xproto.Drawable(win),
+		xproto.GcFunction|xproto.GcLineWidth|xproto.GcSubwindowMode,
+		[]uint32{
+			xproto.GxInvert,
+			1,
+			xproto.SubwindowModeIncludeInferiors,
+		})
+	defer xproto.FreeGC(X, gc)
+
+	xproto.MapWindow(X, win)
+
+	if err = grabPointer(X, win, screen.Root, cursor); err != nil {
+		return
+	}
+	defer xproto.UngrabPointer(X, xproto.TimeCurrentTime)
+
+	if err = grabKeyboard(X, win); err != nil {
+		return
+	}
+	defer xproto.UngrabKeyboard(X, xproto.TimeCurrentTime)
+
+	var start, current image.Point
+	dragging := false
+	drawn := false
+
+	// inverting the same rectangle twice erases it
+	toggle := func() {
+		xproto.PolyRectangle(X, xproto.Drawable(win), gc,
+			[]xproto.Rectangle{toXRect(selectionRect(start, current))})
+		drawn = !drawn
+	}
+
+	// boxes that were already selected are filled. only the parts of r that
+	// none of the others cover are inverted, so overlapping boxes don't
+	// cancel each other out
+	toggleBox := func(r image.Rectangle, others []image.Rectangle) {
+		var xrects []xproto.Rectangle
+		for _, part := range subtractRects(r, others) {
+			xrects = append(xrects, xproto.Rectangle{
+				X:      int16(part.Min.X),
+				Y:      int16(part.Min.Y),
+				Width:  uint16(part.Dx()),
+				Height: uint16(part.Dy()),
+			})
+		}
+		xproto.PolyFillRectangle(X, xproto.Drawable(win), gc, xrects)
+	}
+
+	finish := func() {
+		if drawn {
+			toggle()
+		}
+		for i := len(rects) - 1; i >= 0; i-- {
+			toggleBox(rects[i], rects[:i])
+		}
+		X.Sync()
+	}
+
+	// confirm adds the current selection and returns true if we are done
+	confirm := func() bool {
+		rect := selectionRect(start, current)
+		DebugPrintln("Selected", rect)
+		if drawn {
+			toggle()
+		}
+		dragging = false
+		if !multi {
+			finish()
+			rects = []image.Rectangle{rect}
+			return true
+		}
+		toggleBox(rect, rects)
+		rects = append(rects, rect)
+		return false
+	}
+
+	DebugPrintln("Waiting for region selection...")
+
+	for {
+		ev, xerr := X.WaitForEvent()
+		if ev == nil && xerr == nil {
+			err = errors.New("X connection closed during selection")
+			return
+		}
+		if xerr != nil {
+			DebugPrintln(xerr)
+			continue
+		}
+
+		switch e := ev.(type) {
+		case xproto.ButtonPressEvent:
+			switch e.Detail {
+			case 1:
+				start = image.Pt(int(e.RootX), int(e.RootY))
+				current = start
+				dragging = true
+				toggle()
+			case 3:
+				if multi && !dragging && len(rects) > 0 {
+					last := len(rects) - 1
+					toggleBox(rects[last], rects[:last])
+					rects = rects[:last]
+					break
+				}
+				finish()
+				rects = nil
+				err = &SelectionCanceledError{}
+				return
+			}
+
+		case xproto.MotionNotifyEvent:
+			if !dragging {
+				break
+			}
+			if drawn {
+				toggle()
+			}
+			current = image.Pt(int(e.RootX), int(e.RootY))
+			toggle()
+
+		case xproto.ButtonReleaseEvent:
+			if e.Detail != 1 || !dragging {
+				break
+			}
+			current = image.Pt(int(e.RootX), int(e.RootY))
+			if confirm() {
+				return
+			}
+
+		case xproto.KeyPressEvent:
+			step := int16(1)
+			if e.State&xproto.KeyButMaskShift != 0 {
+				step = 10
+			}
+
+			switch keys[e.Detail] {
+			case keysymEscape:
+				finish()
+				rects = nil
+				err = &SelectionCanceledError{}
+				return
+			case keysymReturn:
+				if dragging && confirm() {
+					return
+				}
+				if multi {
+					finish()
+					return
+				}
+			case keysymLeft:
+				xproto.WarpPointer(X, 0, 0, 0, 0, 0, 0, -step, 0)
+			case keysymRight:
+				xproto.WarpPointer(X, 0, 0, 0, 0, 0, 0, step, 0)
+			case keysymUp:
+				xproto.WarpPointer(X, 0, 0, 0, 0, 0, 0, 0, -step)
+			case keysymDown:
+				xproto.WarpPointer(X, 0, 0, 0, 0, 0, 0, 0, step)
+			}
+		}
+	}
+}
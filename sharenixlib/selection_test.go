@@ -0,0 +1,328 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"errors"
+	"github.com/BurntSushi/xgb"
+	"github.com/BurntSushi/xgb/xproto"
+	"github.com/BurntSushi/xgb/xtest"
+	"image"
+	"testing"
+	"time"
+)
+
+func TestSelectionRect(t *testing.T) {
+	tests := []struct {
+		a, b image.Point
+		want image.Rectangle
+	}{
+		{image.Pt(10, 20), image.Pt(50, 60), image.Rect(10, 20, 51, 61)},
+		{image.Pt(50, 60), image.Pt(10, 20), image.Rect(10, 20, 51, 61)},
+		{image.Pt(50, 20), image.Pt(10, 60), image.Rect(10, 20, 51, 61)},
+		{image.Pt(5, 5), image.Pt(5, 5), image.Rect(5, 5, 6, 6)},
+	}
+
+	for _, test := range tests {
+		got := selectionRect(test.a, test.b)
+		if got != test.want {
+			t.Errorf("selectionRect(%v, %v) = %v, want %v",
+				test.a, test.b, got, test.want)
+		}
+	}
+}
+
+// a fakeInput injects pointer and keyboard events through XTEST on its own
+// connection, like a user would
+type fakeInput struct {
+	t    *testing.T
+	X    *xgb.Conn
+	root xproto.Window
+	keys map[xproto.Keysym]xproto.Keycode
+}
+
+func newFakeInput(t *testing.T, display string) *fakeInput {
+	X := connectXvfb(t, display)
+	if err := xtest.Init(X); err != nil {
+		t.Skip("XTEST is not available:", err)
+	}
+
+	keys, err := getKeyMap(X)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := &fakeInput{
+		t:    t,
+		X:    X,
+		root: xproto.Setup(X).DefaultScreen(X).Root,
+		keys: make(map[xproto.Keysym]xproto.Keycode),
+	}
+	for code, sym := range keys {
+		in.keys[sym] = code
+	}
+	return in
+}
+
+func (in *fakeInput) send(typ, detail byte, x, y int16) {
+	err := xtest.FakeInputChecked(in.X, typ, detail, 0, in.root, x, y,
+		0).Check()
+	if err != nil {
+		in.t.Fatal(err)
+	}
+}
+
+func (in *fakeInput) move(x, y int16) {
+	in.send(xproto.MotionNotify, 0, x, y)
+}
+
+func (in *fakeInput) click(button byte, press bool) {
+	if press {
+		in.send(xproto.ButtonPress, button, 0, 0)
+	} else {
+		in.send(xproto.ButtonRelease, button, 0, 0)
+	}
+}
+
+func (in *fakeInput) drag(from, to image.Point) {
+	in.move(int16(from.X), int16(from.Y))
+	in.click(1, true)
+	in.move(int16(to.X), int16(to.Y))
+	in.click(1, false)
+}
+
+func (in *fakeInput) key(sym xproto.Keysym) {
+	code, ok := in.keys[sym]
+	if !ok {
+		in.t.Fatalf("No keycode for keysym %x", sym)
+	}
+	in.send(xproto.KeyPress, byte(code), 0, 0)
+	in.send(xproto.KeyRelease, byte(code), 0, 0)
+}
+
+// waitForGrab waits until the selector has grabbed the keyboard, which
+// happens right after it grabs the pointer
+func (in *fakeInput) waitForGrab() {
+	for i := 0; i < 500; i++ {
+		reply, err := xproto.GrabKeyboard(in.X, false, in.root,
+			xproto.TimeCurrentTime, xproto.GrabModeAsync,
+			xproto.GrabModeAsync).Reply()
+		if err != nil {
+			in.t.Fatal(err)
+		}
+		if reply.Status == xproto.GrabStatusAlreadyGrabbed {
+			return
+		}
+		xproto.UngrabKeyboard(in.X, xproto.TimeCurrentTime)
+		in.X.Sync()
+		time.Sleep(grabInterval)
+	}
+	in.t.Fatal("The selector never grabbed the keyboard")
+}
+
+type selectResult struct {
+	rects []image.Rectangle
+	err   error
+}
+
+// runSelection starts a selection on a new virtual X server, feeds it input
+// with script and returns its result
+func runSelection(t *testing.T, multi bool, script func(in *fakeInput)) (
+	[]image.Rectangle, error) {
+
+	display := startXvfb(t, 320, 240, 24)
+	X := connectXvfb(t, display)
+	in := newFakeInput(t, display)
+
+	done := make(chan selectResult, 1)
+	go func() {
+		rects, err := selectRects(X, multi)
+		done <- selectResult{rects, err}
+	}()
+
+	in.waitForGrab()
+	script(in)
+
+	select {
+	case res := <-done:
+		return res.rects, res.err
+	case <-time.After(5 * time.Second):
+		t.Fatal("The selection didn't finish")
+	}
+	return nil, nil
+}
+
+func TestSelectRegionDrag(t *testing.T) {
+	rects, err := runSelection(t, false, func(in *fakeInput) {
+		in.drag(image.Pt(50, 60), image.Pt(10, 20))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := image.Rect(10, 20, 51, 61)
+	if len(rects) != 1 || rects[0] != want {
+		t.Errorf("got %v, want [%v]", rects, want)
+	}
+}
+
+func TestSelectRegionNudge(t *testing.T) {
+	rects, err := runSelection(t, false, func(in *fakeInput) {
+		in.move(100, 100)
+		in.click(1, true)
+		for i := 0; i < 3; i++ {
+			in.key(keysymRight)
+		}
+		in.key(keysymDown)
+		in.key(keysymReturn)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := image.Rect(100, 100, 104, 102)
+	if len(rects) != 1 || rects[0] != want {
+		t.Errorf("got %v, want [%v]", rects, want)
+	}
+}
+
+func TestSelectRegionCancel(t *testing.T) {
+	for name, script := range map[string]func(in *fakeInput){
+		"escape": func(in *fakeInput) {
+			in.move(10, 10)
+			in.click(1, true)
+			in.move(30, 30)
+			in.key(keysymEscape)
+		},
+		"right click": func(in *fakeInput) {
+			in.click(3, true)
+		},
+	} {
+		rects, err := runSelection(t, false, script)
+		var canceled *SelectionCanceledError
+		if !errors.As(err, &canceled) {
+			t.Errorf("%s: got %v, %v, want a SelectionCanceledError",
+				name, rects, err)
+		}
+	}
+}
+
+func TestSubtractRects(t *testing.T) {
+	r := image.Rect(0, 0, 10, 10)
+	tests := [][]image.Rectangle{
+		nil,
+		{image.Rect(20, 20, 30, 30)},
+		{image.Rect(2, 2, 5, 5)},
+		{image.Rect(-5, -5, 5, 5)},
+		{image.Rect(-5, 3, 15, 6)},
+		{image.Rect(-1, -1, 11, 11)},
+		{image.Rect(2, 2, 5, 5), image.Rect(4, 4, 8, 12)},
+		{image.Rect(0, 0, 5, 10), image.Rect(5, 0, 10, 5)},
+	}
+
+	for _, others := range tests {
+		parts := subtractRects(r, others)
+
+		// every pixel of r must be in exactly one part unless another
+		// rectangle covers it
+		for y := -2; y < 12; y++ {
+			for x := -2; x < 12; x++ {
+				pt := image.Pt(x, y)
+				want := 0
+				if pt.In(r) {
+					want = 1
+				}
+				for _, o := range others {
+					if pt.In(o) {
+						want = 0
+					}
+				}
+
+				got := 0
+				for _, part := range parts {
+					if pt.In(part) {
+						got++
+					}
+				}
+				if got != want {
+					t.Errorf("%v minus %v: %v is in %d parts, want %d", r,
+						others, pt, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestSelectRedactionsOverlap(t *testing.T) {
+	display := startXvfb(t, 320, 240, 24)
+	X := connectXvfb(t, display)
+	in := newFakeInput(t, display)
+
+	area := image.Rect(0, 0, 100, 100)
+	before, err := CaptureRect(in.X, -1, area)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the highlighted pixels are the inverse of what was on the screen
+	inverted := func(x, y int) bool {
+		img, err := CaptureRect(in.X, -1, area)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r0, g0, b0, _ := before.At(x, y).RGBA()
+		r, g, b, _ := img.At(x, y).RGBA()
+		return r == 0xFFFF-r0 && g == 0xFFFF-g0 && b == 0xFFFF-b0
+	}
+
+	done := make(chan selectResult, 1)
+	go func() {
+		rects, err := selectRects(X, true)
+		done <- selectResult{rects, err}
+	}()
+
+	in.waitForGrab()
+	in.drag(image.Pt(10, 10), image.Pt(59, 59))
+	in.drag(image.Pt(30, 30), image.Pt(79, 79))
+	in.X.Sync()
+	X.Sync()
+
+	// the overlap of the two boxes must stay highlighted
+	for _, pt := range []image.Point{{20, 20}, {40, 40}, {70, 70}} {
+		if !inverted(pt.X, pt.Y) {
+			t.Errorf("%v isn't highlighted", pt)
+		}
+	}
+
+	in.click(3, true)
+	in.click(3, false)
+	in.X.Sync()
+	X.Sync()
+	if !inverted(40, 40) || inverted(70, 70) {
+		t.Error("removing the last box erased the wrong pixels")
+	}
+
+	in.key(keysymReturn)
+	select {
+	case res := <-done:
+		want := []image.Rectangle{image.Rect(10, 10, 60, 60)}
+		if res.err != nil || len(res.rects) != 1 || res.rects[0] != want[0] {
+			t.Errorf("got %v, %v, want %v", res.rects, res.err, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The selection didn't finish")
+	}
+}
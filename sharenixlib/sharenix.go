@@ -19,26 +19,32 @@
 package sharenixlib
 
 import (
+	"archive/zip"
 	"bytes"
+	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"flag"
 	"fmt"
 	"github.com/BurntSushi/xgb"
+	"github.com/BurntSushi/xgb/xproto"
 	"github.com/mattn/go-gtk/gdk"
+	"github.com/mattn/go-gtk/gdkpixbuf"
 	"github.com/mattn/go-gtk/glib"
 	"github.com/mattn/go-gtk/gtk"
 	"html"
-	"image/png"
+	"image"
+	"image/gif"
 	"io"
 	"mvdan.cc/xurls/v2"
-	"net/http"
-	"net/http/httptest"
-	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"reflect"
+	"regexp"
 	"strings"
+	"syscall"
 	"time"
 	"unsafe"
 )
@@ -53,91 +59,78 @@ const (
 
 var ShareNixDebug = false
 
-// -----------------------------------------------------------------------------
-// !! WARNING: Ghetto code ahead !!
-
-var server *httptest.Server
-
-// http://keighl.com/post/mocking-http-responses-in-golang/
-// just cause I'm too lazy to add a special case to the output parsing
-// for plugins
-func fakeResponseStart(code int, body string) (*httptest.Server, *http.Client) {
-	server = httptest.NewServer(
-		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.WriteHeader(code)
-			w.Header().Set("User-Agent", strings.Replace(ShareNixVersion, " ", "/", 1))
-			w.Header().Set("Content-Type", "plain/text")
-			fmt.Fprintln(w, body)
-		}),
-	)
-
-	transport := &http.Transport{
-		Proxy: func(req *http.Request) (*url.URL, error) {
-			return url.Parse(server.URL)
-		},
-	}
-
-	client := &http.Client{Transport: transport}
-	return server, client
-}
-
-func fakeResponseEnd() {
-	if server != nil {
-		server.Close()
-		server = nil
-	}
-}
-
-// -----------------------------------------------------------------------------
-
-// ReplaceKeywords replaces various keywords in the site configuration fields
-// $Y$/%yy: local year padded to 4 digits
-// $M$/%mo: local month padded to 2 digits
-// $D$/%d: local day padded to 2 digits
-// $h$/%h: local hours padded to 2 digits
-// $m$/%mi: local minutes padded to 2 digits
-// $s$/%s: local seconds padded to 2 digits
-// $n$: local nanoseconds
-// $input$, $filename$: whatever is passed as input
-// $extension$: whatever is passed as extension
-func ReplaceKeywords(input, extension string, sitecfg *SiteConfig) {
-	t := time.Now()
-	// TODO: do this in a non-shitty way that is escape-able
-	replacements := map[string]func() string{
-		"$input$":     func() string { return input },
-		"$filename$":  func() string { return input },
-		"$extension$": func() string { return extension },
-		"$Y$":         func() string { return fmt.Sprintf("%04d", t.Year()) },
-		"%yy":         func() string { return fmt.Sprintf("%04d", t.Year()) },
-		"$M$":         func() string { return fmt.Sprintf("%02d", t.Month()) },
-		"%mo":         func() string { return fmt.Sprintf("%02d", t.Month()) },
-		"$D$":         func() string { return fmt.Sprintf("%02d", t.Day()) },
-		"%d":          func() string { return fmt.Sprintf("%02d", t.Day()) },
-		"$h$":         func() string { return fmt.Sprintf("%02d", t.Hour()) },
-		"%h":          func() string { return fmt.Sprintf("%02d", t.Hour()) },
-		"$m$":         func() string { return fmt.Sprintf("%02d", t.Minute()) },
-		"%mi":         func() string { return fmt.Sprintf("%02d", t.Minute()) },
-		"$s$":         func() string { return fmt.Sprintf("%02d", t.Second()) },
-		"%s":          func() string { return fmt.Sprintf("%02d", t.Second()) },
-		"$n$":         func() string { return fmt.Sprintf("%d", t.Nanosecond()) },
-	}
-
-	replacer := func(str string) string {
-		for key, formatter := range replacements {
-			str = strings.Replace(str, key, formatter(), -1)
-		}
-		return str
+// ReplaceKeywords replaces the keywords in the arguments, headers, request
+// url and body template of sitecfg. See ExpandTemplate for the syntax.
+// Parameters are expanded, escaped and moved to the query string of the
+// request url.
+// input is what $input$ and {filename} are replaced with, extension is
+// what $extension$ is replaced with.
+// Keywords that depend on the response are left as they are. Keywords in
+// the Data template of JSON and XML bodies are escaped
+func ReplaceKeywords(input, extension string, sitecfg *SiteConfig) (
+	err error) {
+
+	ctx := &TemplateContext{
+		Input:     input,
+		Extension: extension,
+		Thumbnail: sitecfg.localThumbnail,
+		Time:      time.Now(),
+		Request:   true,
+	}
+
+	expand := func(field, template string) string {
+		if err != nil {
+			return template
+		}
+		var res string
+		res, err = ExpandTemplate(template, ctx)
+		if err != nil {
+			err = fmt.Errorf("%s: %v", field, err)
+		}
+		return res
 	}
 
 	for i := range sitecfg.Arguments {
-		sitecfg.Arguments[i] = replacer(sitecfg.Arguments[i])
+		sitecfg.Arguments[i] = expand("Arguments."+i, sitecfg.Arguments[i])
 	}
 
 	for k, _ := range sitecfg.Headers {
-		sitecfg.Headers[k] = replacer(sitecfg.Headers[k])
+		sitecfg.Headers[k] = expand("Headers."+k, sitecfg.Headers[k])
 	}
 
-	sitecfg.RequestURL = replacer(sitecfg.RequestURL)
+	sitecfg.RequestURL = expand("RequestURL", sitecfg.RequestURL)
+
+	// the parameters are escaped as a whole once they are expanded, escaping
+	// them earlier would break the keywords
+	params := make(map[string]string, len(sitecfg.Parameters))
+	for k, v := range sitecfg.Parameters {
+		params[k] = expand("Parameters."+k, v)
+	}
+	sitecfg.RequestURL = appendQuery(sitecfg.RequestURL, params)
+	sitecfg.Parameters = nil
+
+	// values in the body template are escaped so they can't break it
+	switch sitecfg.Body {
+	case BodyJSON:
+		ctx.Escape = escapeJSON
+	case BodyXML:
+		ctx.Escape = escapeXML
+	}
+	sitecfg.Data = expand("Data", sitecfg.Data)
+	return
+}
+
+// escapeJSON escapes s so that it can be placed inside a json string
+func escapeJSON(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b[1 : len(b)-1])
+}
+
+// escapeXML escapes s so that it can be placed in xml text or attributes
+func escapeXML(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
 }
 
 // UploadFile uploads a file
@@ -148,7 +141,7 @@ func ReplaceKeywords(input, extension string, sitecfg *SiteConfig) {
 // notif: if true, a notification will display during and after the request
 func UploadFile(cfg *Config, sitecfg *SiteConfig, path string,
 	silent, notif, upload bool) (
-	res *http.Response, filename string, newsitecfg *SiteConfig, err error) {
+	res *Response, filename string, newsitecfg *SiteConfig, err error) {
 
 	// this hack fixes "invalid argument" when there's leftover zero bytes
 	// in the paths
@@ -161,34 +154,51 @@ func UploadFile(cfg *Config, sitecfg *SiteConfig, path string,
 		return
 	}
 
+	// compiled before cloning so that every upload to the site reuses them
+	if _, err = sitecfg.CompileRegexList(); err != nil {
+		return
+	}
+
+	// ReplaceKeywords modifies the site config in place
+	sitecfg = sitecfg.Clone()
+
+	cfg.attachThumbnail(sitecfg, path, silent)
+
 	basepath := filepath.Base(path)
 	extension := filepath.Ext(basepath)
-	ReplaceKeywords(basepath, extension, sitecfg)
+	if err = ReplaceKeywords(basepath, extension, sitecfg); err != nil {
+		return
+	}
 
-	Println(silent, "Uploading file to", sitecfg.Name)
+	// the archive keeps the original file, only the uploaded copy is
+	// stripped
+	uploadpath := path
+	if cfg.StripMetadata {
+		var tmpdir string
+		uploadpath, tmpdir, err = StripFileMetadata(path)
+		if err != nil {
+			return
+		}
+		if tmpdir != "" {
+			defer os.RemoveAll(tmpdir)
+		}
+	}
 
-	doThings := func() (res *http.Response, filename string, err error) {
-		if sitecfg.RequestType == "PLUGIN" {
-			var output string
-			output, err = RunPlugin(sitecfg.RequestURL, sitecfg.Arguments)
-			DebugPrintln("RunPlugin returned", len(output), "bytes:",
-				output, "with error", err)
-			if err != nil {
-				return
-			}
+	Println(silent, "Uploading file to", sitecfg.Name)
 
-			server, client := fakeResponseStart(200, output)
-			res, err = client.Get(server.URL + "/")
-			if err != nil {
-				return
-			}
+	doThings := func(progress ProgressFunc) (res *Response,
+		filename string, err error) {
 
+		if sitecfg.RequestType == "PLUGIN" {
+			res, err = sitecfg.runPlugin(uploadpath, basepath)
 			filename = filepath.Base(path)
 			return
 		}
 		return SendRequest(sitecfg.RequestType, sitecfg.RequestURL,
-			sitecfg.FileFormName, path, sitecfg.Arguments,
-			sitecfg.Headers, sitecfg.Username, sitecfg.Password)
+			sitecfg.FileFormName, uploadpath, sitecfg.thumbnailFiles(),
+			sitecfg.Arguments, sitecfg.Body, sitecfg.Data, sitecfg.Headers,
+			sitecfg.Username, sitecfg.Password, progress,
+			sitecfg.RetryPolicy())
 	}
 
 	newsitecfg = sitecfg
@@ -199,7 +209,8 @@ func UploadFile(cfg *Config, sitecfg *SiteConfig, path string,
 			exec.Command(cfg.NotifyCommand, msg).Run()
 		} else {
 			onload := func(w *gtk.Window) {
-				res, filename, err = doThings()
+				res, filename, err = doThings(
+					cfg.uploadProgress(silent, w, msg))
 				glib.IdleAdd(w.Destroy)
 				DebugPrintln("Goroutine is exiting")
 			}
@@ -211,7 +222,7 @@ func UploadFile(cfg *Config, sitecfg *SiteConfig, path string,
 		}
 	}
 
-	res, filename, err = doThings()
+	res, filename, err = doThings(cfg.uploadProgress(silent, nil, ""))
 	return
 }
 
@@ -222,25 +233,23 @@ func UploadFile(cfg *Config, sitecfg *SiteConfig, path string,
 // silent: disables all console output except errors
 // notif: if true, a notification will display during and after the request
 func ShortenUrl(cfg *Config, sitecfg *SiteConfig, url string,
-	silent, notif bool) (res *http.Response, err error) {
+	silent, notif bool) (res *Response, err error) {
 
-	ReplaceKeywords(url, "", sitecfg)
+	if err = ReplaceKeywords(url, "", sitecfg); err != nil {
+		return
+	}
 	Println(silent, "Shortening with", sitecfg.Name)
 
-	doThings := func() (*http.Response, error) {
+	doThings := func() (*Response, error) {
 		switch sitecfg.RequestType {
 		case "PLUGIN":
-			output, err := RunPlugin(sitecfg.RequestURL, sitecfg.Arguments)
-			if err != nil {
-				return nil, err
-			}
-			server, client := fakeResponseStart(200, output)
-			return client.Get(server.URL + "/")
+			return sitecfg.runPlugin("", url)
 		default:
 			res, _, err = SendRequest(sitecfg.RequestType,
-				sitecfg.RequestURL, sitecfg.FileFormName, "",
-				sitecfg.Arguments, sitecfg.Headers, sitecfg.Username,
-				sitecfg.Password)
+				sitecfg.RequestURL, sitecfg.FileFormName, "", nil,
+				sitecfg.Arguments, sitecfg.Body, sitecfg.Data,
+				sitecfg.Headers, sitecfg.Username, sitecfg.Password, nil,
+				sitecfg.RetryPolicy())
 			return res, err
 		}
 	}
@@ -263,73 +272,477 @@ func ShortenUrl(cfg *Config, sitecfg *SiteConfig, url string,
 	return doThings()
 }
 
+// cursorError adds context to a failure to draw the mouse pointer
+func cursorError(err error) error {
+	if err != nil {
+		return fmt.Errorf("Failed to draw the mouse pointer: %v", err)
+	}
+	return nil
+}
+
 // UploadFullScreen captures a full screen screenshot,
-// saves it in the archive and uploads it
+// saves it in the archive and uploads it.
+// cfg.CaptureGeometry and cfg.CaptureHead restrict the screenshot to a
+// rectangle or a single monitor. Without either, the cfg.XineramaHead monitor
+// is captured, and CaptureHead "all" captures every monitor.
+// cfg.CaptureCursor includes the mouse pointer
 // cfg: the ShareNix config
 // sitecfg: the target site config
 // silent: disables all console output except errors
 // notif: if true, a notification will display during and after the request
 func UploadFullScreen(cfg *Config, sitecfg *SiteConfig, silent, notif,
 	upload bool) (
-	res *http.Response, file string, newsitecfg *SiteConfig, err error) {
+	res *Response, file string, newsitecfg *SiteConfig, err error) {
 
 	newsitecfg = sitecfg
 
-	Println(silent, "Taking screenshot...")
 	X, err := xgb.NewConn()
 	if err != nil {
 		return
 	}
 	defer X.Close()
+	defer ReleaseShm(X)
+
+	selected, err := selectRedactions(X, cfg, silent)
+	if err != nil {
+		return
+	}
+
+	head := cfg.CaptureHead
+	if head == "" {
+		head = "default"
+	}
 
 	// capture screen
-	img, err := CaptureScreen(X)
+	var capture func() (*image.RGBA, error)
+	switch {
+	case cfg.CaptureGeometry != "":
+		var rect image.Rectangle
+		rect, err = ParseGeometry(cfg.CaptureGeometry)
+		if err != nil {
+			return
+		}
+		rect, err = clipToScreen(rect, xproto.Setup(X).DefaultScreen(X))
+		if err != nil {
+			return
+		}
+		capture = func() (*image.RGBA, error) {
+			return captureRect(X, cfg, rect, selected, silent)
+		}
+
+	case head != "all":
+		var screen *ScreenRect
+		screen, err = FindHead(X, head, cfg.XineramaHead)
+		if err != nil {
+			return
+		}
+		capture = func() (img *image.RGBA, err error) {
+			Println(silent, "Taking screenshot...")
+			img, err = screen.Capture(X)
+			if err != nil {
+				return
+			}
+			origin := screen.RootRect().Min
+			if cfg.CaptureCursor {
+				err = cursorError(DrawCursor(X, img, origin))
+				if err != nil {
+					return
+				}
+			}
+			err = redactCapture(cfg, img, origin, selected)
+			return
+		}
+
+	default:
+		var rects []*ScreenRect
+		rects, err = ScreenRects(X)
+		if err != nil {
+			return
+		}
+
+		// the default screen's root window starts at the top left corner
+		// of the bounding box of all monitors
+		var bounds image.Rectangle
+		for _, r := range rects {
+			bounds = bounds.Union(r.Rect)
+		}
+
+		capture = func() (img *image.RGBA, err error) {
+			Println(silent, "Taking screenshot...")
+			img, err = CaptureScreen(X)
+			if err != nil {
+				return
+			}
+			if cfg.CaptureCursor {
+				err = cursorError(DrawScreenCursor(X, img))
+				if err != nil {
+					return
+				}
+			}
+			err = redactCapture(cfg, img, bounds.Min, selected)
+			return
+		}
+	}
+
+	return UploadCaptures(cfg, sitecfg, capture, silent, notif, upload)
+}
+
+// captureRect captures a section of the default screen, including the mouse
+// pointer if cfg.CaptureCursor is set, and hides the redacted areas
+// selected: redaction boxes picked by the user in root coordinates
+func captureRect(X *xgb.Conn, cfg *Config, rect image.Rectangle,
+	selected []image.Rectangle, silent bool) (img *image.RGBA, err error) {
+
+	Println(silent, "Taking screenshot...")
+	img, err = CaptureRect(X, -1, rect)
+	if err != nil {
+		return
+	}
+
+	if cfg.CaptureCursor {
+		err = cursorError(DrawCursor(X, img, rect.Min))
+		if err != nil {
+			return
+		}
+	}
+
+	err = redactCapture(cfg, img, rect.Min, selected)
+	return
+}
+
+// parseRedactions parses cfg.Redact
+func parseRedactions(cfg *Config) (rects []image.Rectangle, err error) {
+	for _, str := range cfg.Redact {
+		var r image.Rectangle
+		r, err = ParseRedactRect(str)
+		if err != nil {
+			return
+		}
+		rects = append(rects, r)
+	}
+	return
+}
+
+// selectRedactions checks cfg.Redact and lets the user drag redaction boxes
+// on the screen if cfg.RedactSelect is set
+func selectRedactions(X *xgb.Conn, cfg *Config, silent bool) (
+	selected []image.Rectangle, err error) {
+
+	if _, err = parseRedactions(cfg); err != nil || !cfg.RedactSelect {
+		return
+	}
+
+	Println(silent, "Drag boxes over the areas to redact, then press "+
+		"Return...")
+	return SelectRedactions(X)
+}
+
+// redactCapture hides the areas in cfg.Redact, which are relative to the
+// screenshot, and the selected boxes, which are in root coordinates, on a
+// screenshot whose top left corner is at origin in root coordinates
+func redactCapture(cfg *Config, img *image.RGBA, origin image.Point,
+	selected []image.Rectangle) (err error) {
+
+	rects, err := parseRedactions(cfg)
+	if err != nil {
+		return
+	}
+
+	for _, r := range selected {
+		rects = append(rects, r.Sub(origin))
+	}
+
+	return Redact(img, rects, cfg.RedactStyle, cfg.RedactPixelSize)
+}
+
+// UploadSection lets the user select a region of the screen, captures it,
+// saves it in the archive and uploads it
+// cfg: the ShareNix config
+// sitecfg: the target site config
+// silent: disables all console output except errors
+// notif: if true, a notification will display during and after the request
+func UploadSection(cfg *Config, sitecfg *SiteConfig, silent, notif,
+	upload bool) (
+	res *Response, file string, newsitecfg *SiteConfig, err error) {
+
+	newsitecfg = sitecfg
+
+	X, err := xgb.NewConn()
+	if err != nil {
+		return
+	}
+	defer X.Close()
+	defer ReleaseShm(X)
+
+	Println(silent, "Select a region of the screen...")
+	rect, err := SelectRegion(X)
+	if err != nil {
+		return
+	}
+
+	selected, err := selectRedactions(X, cfg, silent)
+	if err != nil {
+		return
+	}
+
+	capture := func() (*image.RGBA, error) {
+		return captureRect(X, cfg, rect, selected, silent)
+	}
+
+	return UploadCaptures(cfg, sitecfg, capture, silent, notif, upload)
+}
+
+// UploadWindow captures a window, saves it in the archive and uploads it
+// cfg: the ShareNix config
+// sitecfg: the target site config
+// pick: if true, the user clicks the window to capture, otherwise the
+//       active window is captured
+// silent: disables all console output except errors
+// notif: if true, a notification will display during and after the request
+func UploadWindow(cfg *Config, sitecfg *SiteConfig, pick, silent, notif,
+	upload bool) (
+	res *Response, file string, newsitecfg *SiteConfig, err error) {
+
+	newsitecfg = sitecfg
+
+	X, err := xgb.NewConn()
+	if err != nil {
+		return
+	}
+	defer X.Close()
+	defer ReleaseShm(X)
+
+	var capture func() (*image.RGBA, error)
+	if pick {
+		Println(silent, "Click on a window...")
+		var rect image.Rectangle
+		rect, err = PickWindowRect(X, cfg.WindowFrame)
+		if err != nil {
+			return
+		}
+
+		var selected []image.Rectangle
+		selected, err = selectRedactions(X, cfg, silent)
+		if err != nil {
+			return
+		}
+
+		capture = func() (*image.RGBA, error) {
+			return captureRect(X, cfg, rect, selected, silent)
+		}
+	} else {
+		var selected []image.Rectangle
+		selected, err = selectRedactions(X, cfg, silent)
+		if err != nil {
+			return
+		}
+
+		// the active window can change during the delay and between
+		// captures, so it's looked up every time
+		capture = func() (*image.RGBA, error) {
+			rect, err := ActiveWindowRect(X, cfg.WindowFrame)
+			if err != nil {
+				return nil, err
+			}
+			return captureRect(X, cfg, rect, selected, silent)
+		}
+	}
+
+	return UploadCaptures(cfg, sitecfg, capture, silent, notif, upload)
+}
+
+// UploadRecording lets the user select a region of the screen, records it
+// until StopRecording is called, the process is interrupted or
+// cfg.RecordMaxDuration elapses, saves it in the archive and uploads it
+// cfg: the ShareNix config
+// sitecfg: the target site config
+// silent: disables all console output except errors
+// notif: if true, a notification will display during and after the request
+func UploadRecording(cfg *Config, sitecfg *SiteConfig, silent, notif,
+	upload bool) (
+	res *Response, file string, newsitecfg *SiteConfig, err error) {
+
+	newsitecfg = sitecfg
+
+	X, err := xgb.NewConn()
+	if err != nil {
+		return
+	}
+	defer X.Close()
+	defer ReleaseShm(X)
+
+	Println(silent, "Select a region of the screen...")
+	rect, err := SelectRegion(X)
 	if err != nil {
 		return
 	}
 
-	// save to archive
-	afilepath, err := GenerateArchivedFilename(".png")
+	format := cfg.RecordFormat
+	afilepath, err := GenerateArchivedFilename("." + format)
 	if err != nil {
 		return
 	}
 
-	tmpfile, err := os.Create(afilepath)
+	// the lock is taken before anything is created so that a second
+	// recording doesn't leave an encoder or an empty file behind
+	pidfile, err := recordingBegin()
 	if err != nil {
 		return
 	}
+	defer recordingEnd(pidfile)
+
+	// failed recordings aren't kept in the archive
+	defer func() {
+		if err != nil && file == "" {
+			os.Remove(afilepath)
+		}
+	}()
+
+	var enc FrameEncoder
+	if cfg.FFmpegCommand != "" {
+		enc, err = NewFFmpegEncoder(cfg.FFmpegCommand, afilepath, format,
+			rect.Dx(), rect.Dy(), cfg.RecordFPS)
+		if err != nil {
+			return
+		}
+	} else if format == "gif" {
+		var tmpfile *os.File
+		tmpfile, err = os.Create(afilepath)
+		if err != nil {
+			return
+		}
+		defer tmpfile.Close()
+		enc = NewGifEncoder(tmpfile, cfg.RecordFPS)
+	} else {
+		err = fmt.Errorf("Recording to %s requires FFmpegCommand", format)
+		return
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGUSR1)
+	defer signal.Stop(sigs)
+
+	// done ends the goroutine if the recording stops without a signal
+	done := make(chan bool)
+	defer close(done)
+
+	stop := make(chan bool, 1)
+	go func() {
+		select {
+		case <-sigs:
+			stop <- true
+		case <-done:
+		}
+	}()
 
-	err = png.Encode(tmpfile, img)
-	tmpfile.Close()
+	Println(silent, "Recording... press Ctrl+C or run sharenix -m=r again "+
+		"to stop")
+	err = RecordRect(X, rect, cfg.RecordFPS,
+		time.Duration(cfg.RecordMaxDuration*float64(time.Second)), stop, enc)
+	if err != nil {
+		return
+	}
 
+	file = afilepath
 	if !upload {
 		return
 	}
 
+	// videos can't go to image hosts
+	if format != "gif" {
+		sitecfg, err = cfg.fileUploaderFor(sitecfg)
+		if err != nil {
+			return
+		}
+	}
+
+	return UploadFile(cfg, sitecfg, afilepath, silent, notif, upload)
+}
+
+// UploadImage saves an image in the archive and uploads it
+// cfg: the ShareNix config
+// sitecfg: the target site config
+// img: the image to upload
+// silent: disables all console output except errors
+// notif: if true, a notification will display during and after the request
+func UploadImage(cfg *Config, sitecfg *SiteConfig, img image.Image, silent,
+	notif, upload bool) (
+	res *Response, file string, newsitecfg *SiteConfig, err error) {
+
+	newsitecfg = sitecfg
+
+	afilepath, err := ArchiveImage(cfg, img)
+	if err != nil || !upload {
+		return
+	}
+
+	return uploadArchivedImage(cfg, sitecfg, afilepath, silent, notif)
+}
+
+// ArchiveImage runs the image tasks in the config on an image, encodes it
+// with the configured format and settings and saves it in the archive
+func ArchiveImage(cfg *Config, img image.Image) (path string, err error) {
+	img, err = ProcessImage(img, cfg.ImageTasks)
+	if err != nil {
+		return
+	}
+
+	data, extension, err := EncodeImage(cfg, img)
+	if err != nil {
+		return
+	}
+
+	tmpfile, path, err := CreateArchiveFile(extension)
+	if err != nil {
+		return
+	}
+
+	_, err = tmpfile.Write(data)
+	closeerr := tmpfile.Close()
+	if err == nil {
+		err = closeerr
+	}
+	return
+}
+
+// uploadArchivedImage uploads an image that was saved by ArchiveImage
+func uploadArchivedImage(cfg *Config, sitecfg *SiteConfig, afilepath string,
+	silent, notif bool) (
+	res *Response, file string, newsitecfg *SiteConfig, err error) {
+
+	// compiled before cloning so that every upload to the site reuses them
+	if _, err = sitecfg.CompileRegexList(); err != nil {
+		return
+	}
+
+	// ReplaceKeywords modifies the site config in place
+	sitecfg = sitecfg.Clone()
+	newsitecfg = sitecfg
+
+	cfg.attachThumbnail(sitecfg, afilepath, silent)
+
 	// TODO: avoid repeating this loop in every upload function and move
 	// it to its own func
 	basepath := filepath.Base(afilepath)
 	extension := filepath.Ext(basepath)
-	ReplaceKeywords(basepath, extension, sitecfg)
+	if err = ReplaceKeywords(basepath, extension, sitecfg); err != nil {
+		return
+	}
 
 	// upload
 	Println(silent, "Uploading to", sitecfg.Name)
 
-	doThings := func() (*http.Response, string, error) {
+	doThings := func(progress ProgressFunc) (*Response, string, error) {
 		switch sitecfg.RequestType {
 		case "PLUGIN":
-			output, err := RunPlugin(
-				sitecfg.RequestURL, sitecfg.Arguments)
-			if err != nil {
-				return nil, "", err
-			}
-			server, client := fakeResponseStart(200, output)
-			res, err := client.Get(server.URL + "/")
+			res, err := sitecfg.runPlugin(afilepath, basepath)
 			return res, filepath.Base(afilepath), err
 		default:
 			return SendRequest(sitecfg.RequestType, sitecfg.RequestURL,
-				sitecfg.FileFormName, afilepath, sitecfg.Arguments,
-				sitecfg.Headers, sitecfg.Username, sitecfg.Password)
+				sitecfg.FileFormName, afilepath, sitecfg.thumbnailFiles(),
+				sitecfg.Arguments, sitecfg.Body, sitecfg.Data,
+				sitecfg.Headers, sitecfg.Username, sitecfg.Password,
+				progress, sitecfg.RetryPolicy())
 		}
 	}
 
@@ -339,7 +752,8 @@ func UploadFullScreen(cfg *Config, sitecfg *SiteConfig, silent, notif,
 			err = exec.Command(cfg.NotifyCommand, msg).Run()
 		} else {
 			onload := func(w *gtk.Window) {
-				res, file, err = doThings()
+				res, file, err = doThings(
+					cfg.uploadProgress(silent, w, msg))
 				glib.IdleAdd(w.Destroy)
 				DebugPrintln("Goroutine is exiting")
 			}
@@ -348,8 +762,188 @@ func UploadFullScreen(cfg *Config, sitecfg *SiteConfig, silent, notif,
 		}
 	}
 
-	newsitecfg = sitecfg
-	res, file, err = doThings()
+	newsitecfg = sitecfg
+	res, file, err = doThings(cfg.uploadProgress(silent, nil, ""))
+	return
+}
+
+// uploadProgress returns the ProgressFunc for an upload. It calls
+// cfg.Progress, draws the terminal progress bar unless silent is true and,
+// if win is not nil, shows the percentage next to msg in the uploading
+// notification
+func (cfg *Config) uploadProgress(silent bool, win *gtk.Window,
+	msg string) ProgressFunc {
+
+	var notifProgress ProgressFunc
+	if win != nil {
+		lastPercent := -1
+		notifProgress = throttleProgress(func(sent, total int64) {
+			percent := Percentage(sent, total)
+			if percent == lastPercent {
+				return
+			}
+			lastPercent = percent
+			SetNotificationMarkup(win, "%s %d%%", msg, percent)
+		})
+	}
+
+	return combineProgress(cfg.Progress, TerminalProgress(silent),
+		notifProgress)
+}
+
+// countdown waits for the given delay, showing a notification during the
+// wait if notif is true
+func countdown(cfg *Config, delay time.Duration, silent, notif bool) (
+	err error) {
+
+	if delay <= 0 {
+		return
+	}
+
+	msg := fmt.Sprintf("Taking screenshot in %v...", delay)
+	Println(silent, msg)
+
+	if notif {
+		if cfg.NotifyCommand == "" {
+			// the notification blocks until it expires
+			return Notifyf(cfg.XineramaHead, delay, nil, msg)
+		}
+		exec.Command(cfg.NotifyCommand, msg).Run()
+	}
+
+	time.Sleep(delay)
+	return
+}
+
+// UploadCaptures waits cfg.CaptureDelay seconds, then calls capture
+// cfg.CaptureCount times every cfg.CaptureInterval seconds. Every capture
+// is saved in the archive. If cfg.BundleCaptures is set, the captures are
+// uploaded together as a zip file, otherwise they are uploaded one by one
+// and the urls of all but the last one are printed right away.
+// If upload is false, file is the zip file or the last capture.
+// cfg: the ShareNix config
+// sitecfg: the target site config
+// capture: takes a single screenshot
+// silent: disables all console output except errors
+// notif: if true, a notification will display during and after the request
+func UploadCaptures(cfg *Config, sitecfg *SiteConfig,
+	capture func() (*image.RGBA, error), silent, notif, upload bool) (
+	res *Response, file string, newsitecfg *SiteConfig, err error) {
+
+	newsitecfg = sitecfg
+
+	delay := time.Duration(cfg.CaptureDelay * float64(time.Second))
+	if err = countdown(cfg, delay, silent, notif); err != nil {
+		return
+	}
+
+	if cfg.CaptureCount <= 1 {
+		var img *image.RGBA
+		img, err = capture()
+		if err != nil {
+			return
+		}
+		return UploadImage(cfg, sitecfg, img, silent, notif, upload)
+	}
+
+	interval := time.Duration(cfg.CaptureInterval * float64(time.Second))
+	paths := make([]string, 0, cfg.CaptureCount)
+	start := time.Now()
+
+	for i := 0; i < cfg.CaptureCount; i++ {
+		// captures are scheduled from the start so that slow captures
+		// don't make the interval drift
+		time.Sleep(time.Until(start.Add(time.Duration(i) * interval)))
+
+		Println(silent, "Capture", i+1, "of", cfg.CaptureCount)
+		var img *image.RGBA
+		img, err = capture()
+		if err != nil {
+			return
+		}
+
+		var afilepath string
+		afilepath, err = ArchiveImage(cfg, img)
+		if err != nil {
+			return
+		}
+		paths = append(paths, afilepath)
+	}
+
+	last := len(paths) - 1
+	if cfg.BundleCaptures {
+		var bundle string
+		bundle, err = BundleFiles(paths)
+		if err != nil {
+			return
+		}
+		if !upload {
+			file = bundle
+			return
+		}
+		sitecfg, err = cfg.fileUploaderFor(sitecfg)
+		if err != nil {
+			return
+		}
+		return UploadFile(cfg, sitecfg, bundle, silent, notif, upload)
+	}
+
+	if !upload {
+		file = paths[last]
+		return
+	}
+
+	for _, afilepath := range paths[:last] {
+		res, file, newsitecfg, err = uploadArchivedImage(cfg, sitecfg,
+			afilepath, silent, notif)
+		if err != nil {
+			return
+		}
+
+		url, thumburl, deleteurl, perr := ParseResponse(newsitecfg, res, file)
+		if perr != nil {
+			fmt.Fprintln(os.Stderr, perr)
+			continue
+		}
+		printResult(silent, url, thumburl, deleteurl)
+	}
+
+	return uploadArchivedImage(cfg, sitecfg, paths[last], silent, notif)
+}
+
+// BundleFiles stores a list of files in a new zip file in the archive
+func BundleFiles(paths []string) (path string, err error) {
+	tmpfile, path, err := CreateArchiveFile(".zip")
+	if err != nil {
+		return
+	}
+	defer tmpfile.Close()
+
+	w := zip.NewWriter(tmpfile)
+	for _, p := range paths {
+		var dst io.Writer
+		dst, err = w.Create(filepath.Base(p))
+		if err != nil {
+			return
+		}
+
+		var src *os.File
+		src, err = os.Open(p)
+		if err != nil {
+			return
+		}
+		_, err = io.Copy(dst, src)
+		src.Close()
+		if err != nil {
+			return
+		}
+	}
+
+	if err = w.Close(); err != nil {
+		return
+	}
+
+	err = tmpfile.Sync()
 	return
 }
 
@@ -394,6 +988,102 @@ func ArchiveFile(path string) (err error) {
 	return
 }
 
+// pixbufImage copies the pixels of a gdk pixbuf to an image.NRGBA.
+// returns nil for pixel layouts other than 8-bit RGB and RGBA
+func pixbufImage(pixbuf *gdkpixbuf.Pixbuf) *image.NRGBA {
+	channels := pixbuf.GetNChannels()
+	if pixbuf.GetColorspace() != gdkpixbuf.GDK_COLORSPACE_RGB ||
+		pixbuf.GetBitsPerSample() != 8 || (channels != 3 && channels != 4) {
+		return nil
+	}
+
+	w, h := pixbuf.GetWidth(), pixbuf.GetHeight()
+	stride := pixbuf.GetRowstride()
+	pixels := pixbuf.GetPixels()
+
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		src := pixels[y*stride : y*stride+w*channels]
+		dst := img.Pix[y*img.Stride : y*img.Stride+w*4]
+		for x := 0; x < w; x++ {
+			copy(dst[x*4:x*4+3], src[x*channels:x*channels+3])
+			if channels == 4 {
+				dst[x*4+3] = src[x*channels+3]
+			} else {
+				dst[x*4+3] = 0xFF
+			}
+		}
+	}
+
+	return img
+}
+
+// canProcessFile returns true if the image tasks in the config should run on
+// a file. bmp can't be decoded by the standard library and animated gifs
+// would lose their animation
+func canProcessFile(cfg *Config, path string) (ok bool, err error) {
+	if len(cfg.ImageTasks) == 0 {
+		return
+	}
+
+	mimeType, err := SniffMimeType(path)
+	if err != nil {
+		return
+	}
+
+	switch mimeType {
+	case "image/png", "image/jpeg":
+		return true, nil
+
+	case "image/gif":
+		var f *os.File
+		f, err = os.Open(path)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+
+		var anim *gif.GIF
+		anim, err = gif.DecodeAll(f)
+		if err != nil {
+			return
+		}
+		if len(anim.Image) > 1 {
+			DebugPrintln("Not processing animated gif", path)
+			return false, nil
+		}
+		return true, nil
+	}
+
+	return
+}
+
+// ArchiveImageFile decodes an image file and saves it in the archive through
+// ArchiveImage, so that it goes through the image tasks in the config.
+// The file keeps its format unless cfg.ImageFormat is set
+func ArchiveImageFile(cfg *Config, path string) (apath string, err error) {
+	path = string(bytes.TrimRight([]byte(path), "\000"))
+
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	img, format, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return
+	}
+
+	DebugPrintln("Decoded", format, "image", path)
+
+	if cfg.ImageFormat == "" {
+		filecfg := *cfg
+		filecfg.ImageFormat = format
+		cfg = &filecfg
+	}
+	return ArchiveImage(cfg, img)
+}
+
 // UploadClipboard grabs an image or a file from the clipboard,
 // saves it in the archive and uploads it
 // cfg: the ShareNix config
@@ -402,7 +1092,7 @@ func ArchiveFile(path string) (err error) {
 // notif: if true, a notification will display during and after the request
 func UploadClipboard(cfg *Config, sitecfg *SiteConfig, silent, notif,
 	upload bool) (
-	res *http.Response, filename string, newsitecfg *SiteConfig, err error) {
+	res *Response, filename string, newsitecfg *SiteConfig, err error) {
 
 	defaultConfig := sitecfg.Name == cfg.DefaultFileUploader
 	newsitecfg = sitecfg
@@ -503,20 +1193,27 @@ func UploadClipboard(cfg *Config, sitecfg *SiteConfig, silent, notif,
 			"Height:", pixbuf.GetHeight(),
 			"Rowstride:", pixbuf.GetRowstride())
 
-		// touch archive file
 		var afilepath string
-		var tmpfile *os.File
-		tmpfile, afilepath, err = CreateArchiveFile(".png")
-		if err != nil {
-			return
-		}
-		tmpfile.Close()
+		if img := pixbufImage(pixbuf); img != nil {
+			afilepath, err = ArchiveImage(cfg, img)
+			if err != nil {
+				return
+			}
+		} else {
+			// touch archive file
+			var tmpfile *os.File
+			tmpfile, afilepath, err = CreateArchiveFile(".png")
+			if err != nil {
+				return
+			}
+			tmpfile.Close()
 
-		// let gtk save it as a proper png so we don't have to
-		// figure out what type of image we are dealing with
-		pixbuf.Save(afilepath, "png")
-		// TODO: for some reason this always returns an err which
-		// prints as nil so we can't error check here :(
+			// let gtk save it as a proper png so we don't have to
+			// figure out what type of image we are dealing with
+			pixbuf.Save(afilepath, "png")
+			// TODO: for some reason this always returns an err which
+			// prints as nil so we can't error check here :(
+		}
 
 		if !upload {
 			return
@@ -531,6 +1228,291 @@ func UploadClipboard(cfg *Config, sitecfg *SiteConfig, silent, notif,
 	return
 }
 
+// ParseResponse extracts the url, thumbnail url and deletion url from the
+// response to an upload and appends them to the upload history if the
+// response is valid. Failed uploads are added to the history with their
+// error message
+// sitecfg: the site config the upload was made with
+// res: the response to the upload request
+// filename: the name of the uploaded file (or the shortened url)
+func ParseResponse(sitecfg *SiteConfig, res *Response, filename string) (
+	url, thumburl, deleteurl string, err error) {
+
+	if res == nil {
+		err = fmt.Errorf("Request failed, but I don't know why!")
+		return
+	}
+
+	switch sitecfg.ResponseType {
+	case "", "Text", "Headers", "RedirectionURL":
+	default:
+		err = fmt.Errorf("Unrecognized ResponseType %q",
+			sitecfg.ResponseType)
+		return
+	}
+
+	// parse response. the body is ignored when the urls come from the
+	// headers
+	DebugPrintln("Parsing response...")
+	headers := sitecfg.ResponseType == "Headers"
+	rbody := res.Body
+	if headers {
+		rbody = nil
+	}
+
+	DebugPrintln(res.Status(), res.Header)
+	DebugPrintln(string(rbody))
+
+	// parse all regular expressions
+	regexps, err := sitecfg.CompileRegexList()
+	if err != nil {
+		return
+	}
+	results := ParseRegexList(string(rbody), regexps)
+
+	// replace regular expressions and other tags in urls
+	ctx := &TemplateContext{
+		Input:        filename,
+		Thumbnail:    sitecfg.localThumbnail,
+		Time:         time.Now(),
+		Response:     rbody,
+		ResponseURL:  res.URL,
+		Status:       res.StatusCode,
+		Header:       res.Header,
+		RegexResults: results,
+	}
+
+	// failed reports the upload as failed with the server's error message
+	failed := func(fallback string) {
+		uploaderr := sitecfg.uploadError(ctx, fallback)
+		AppendErrorToHistory(filename, uploaderr.Message)
+		url, thumburl, deleteurl, err = "", "", "", uploaderr
+	}
+
+	ok, err := sitecfg.succeeded(ctx)
+	if err != nil {
+		DebugPrintln("Failed to parse the response:", string(rbody))
+		return
+	}
+	if !ok {
+		if res.plugin != nil && res.plugin.Error != "" {
+			failed(res.plugin.Error)
+		} else {
+			failed(string(rbody))
+		}
+		return
+	}
+
+	if sitecfg.ResponseType == "RedirectionURL" {
+		DebugPrintln("Getting redirection url...")
+		url = ctx.ResponseURL
+	} else {
+		url, err = parseResponseField("URL", sitecfg.URL, ctx)
+	}
+	if err == nil {
+		thumburl, err = parseResponseField("ThumbnailURL",
+			sitecfg.ThumbnailURL, ctx)
+	}
+	if err == nil {
+		deleteurl, err = parseResponseField("DeletionURL",
+			sitecfg.DeletionURL, ctx)
+	}
+	if err != nil {
+		DebugPrintln("Failed to parse the response:", string(rbody))
+		url, thumburl, deleteurl = "", "", ""
+		return
+	}
+
+	// json plugins return the urls directly
+	if res.plugin != nil {
+		if len(url) == 0 {
+			url = res.plugin.URL
+		}
+		if len(thumburl) == 0 {
+			thumburl = res.plugin.ThumbnailURL
+		}
+		if len(deleteurl) == 0 {
+			deleteurl = res.plugin.DeletionURL
+		}
+	}
+
+	// empty url = take entire response or the Location header as url
+	if len(url) == 0 && headers {
+		location, lerr := res.Location()
+		if lerr != nil {
+			err = fmt.Errorf("Request failed: %v", lerr)
+			return
+		}
+		url = location.String()
+	} else if len(url) == 0 {
+		url = string(rbody)
+	}
+
+	url = strings.TrimSuffix(url, "\n")
+	if !isURL(url) {
+		// the result must only contain an url with no extra stuff to be
+		// considered a valid response
+		failed(url)
+		return
+	}
+
+	historythumb := thumburl
+	if len(historythumb) == 0 {
+		historythumb = sitecfg.localThumbnail
+	}
+	AppendToHistory(url, historythumb, deleteurl, filename)
+	return
+}
+
+// succeeded checks the status and SuccessCondition of a response
+func (sitecfg *SiteConfig) succeeded(ctx *TemplateContext) (ok bool,
+	err error) {
+
+	accepted := sitecfg.SuccessStatus
+	if len(accepted) == 0 {
+		accepted = []string{"2xx"}
+	}
+
+	for _, cond := range accepted {
+		if !validStatus(cond) {
+			err = fmt.Errorf("Invalid SuccessStatus: %q", cond)
+			return
+		}
+		ok = ok || matchStatus(cond, ctx.Status)
+	}
+	if !ok || sitecfg.SuccessCondition == "" {
+		return
+	}
+
+	return evalCondition("SuccessCondition", sitecfg.SuccessCondition, ctx)
+}
+
+// evalCondition evaluates a condition template, which is true unless it's
+// empty, false, 0 or null. "a == b" and "a != b" compare two templates
+func evalCondition(field, cond string, ctx *TemplateContext) (ok bool,
+	err error) {
+
+	for _, op := range []string{" == ", " != "} {
+		i := strings.Index(cond, op)
+		if i < 0 {
+			continue
+		}
+
+		var left, right string
+		left, err = parseResponseField(field, cond[:i], ctx)
+		if err != nil {
+			return
+		}
+		right, err = parseResponseField(field, cond[i+len(op):], ctx)
+		if err != nil {
+			return
+		}
+
+		ok = strings.TrimSpace(left) == strings.TrimSpace(right)
+		if op == " != " {
+			ok = !ok
+		}
+		return
+	}
+
+	value, err := parseResponseField(field, cond, ctx)
+	if err != nil {
+		return
+	}
+
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "false", "0", "null":
+		return false, nil
+	}
+	return true, nil
+}
+
+// uploadError returns the error of a failed upload. the message is taken
+// from ErrorMessage, or is the start of fallback if there is none
+func (sitecfg *SiteConfig) uploadError(ctx *TemplateContext,
+	fallback string) *UploadError {
+
+	message := ""
+	if sitecfg.ErrorMessage != "" {
+		var err error
+		message, err = parseResponseField("ErrorMessage",
+			sitecfg.ErrorMessage, ctx)
+		if err != nil {
+			DebugPrintln(err)
+		}
+	}
+
+	if strings.TrimSpace(message) == "" {
+		message = strings.TrimSpace(fallback)
+		if len(message) > maxResponseErrorLength {
+			message = message[:maxResponseErrorLength] + "..."
+		}
+	}
+
+	return &UploadError{StatusCode: ctx.Status, Message: message}
+}
+
+// anySchemeURL matches urls with a scheme that xurls doesn't know, such as
+// ipfs://
+var anySchemeURL, _ = xurls.StrictMatchingScheme(`[a-z][a-z0-9+.-]*://`)
+
+var strictURL = xurls.Strict()
+
+// isURL returns true if s is a single url and nothing else
+func isURL(s string) bool {
+	for _, re := range []*regexp.Regexp{strictURL, anySchemeURL} {
+		loc := re.FindStringIndex(s)
+		if loc != nil && loc[0] == 0 && loc[1] == len(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestFailure returns the error for a request that failed with err.
+// If the retries ran out, res is the last response and the error message of
+// the site is extracted from it like for any other failed upload
+func requestFailure(sitecfg *SiteConfig, res *Response, filename string,
+	err error) error {
+
+	if res == nil {
+		return err
+	}
+
+	_, _, _, perr := ParseResponse(sitecfg, res, filename)
+	var uploaderr *UploadError
+	if !errors.As(perr, &uploaderr) {
+		return err
+	}
+	DebugPrintln(err)
+	return perr
+}
+
+// notifyError shows err as a notification
+func notifyError(cfg *Config, err error) {
+	if cfg.NotifyCommand != "" {
+		exec.Command(cfg.NotifyCommand, err.Error()).Run()
+	} else {
+		Notifyf(cfg.XineramaHead,
+			time.Second*time.Duration(cfg.NotificationTime), nil,
+			html.EscapeString(err.Error()))
+	}
+}
+
+// printResult displays the urls returned by an upload
+func printResult(silent bool, url, thumburl, deleteurl string) {
+	if !silent {
+		fmt.Printf("URL: ")
+	}
+	fmt.Println(url)
+	if len(thumburl) > 0 {
+		Println(silent, "Thumbnail URL:", thumburl)
+	}
+	if len(deleteurl) > 0 {
+		Println(silent, "Deletion URL:", deleteurl)
+	}
+}
+
 /*
 	ShareNix uploads a file with the given options
 	cfg: ShareNix config
@@ -538,8 +1520,11 @@ func UploadClipboard(cfg *Config, sitecfg *SiteConfig, silent, notif,
 		f/file: upload file
 		fs/fullscreen: screenshot entire screen and upload
 		s/section: select screen region and upload
+		w/window: screenshot the active window and upload
+		pw/pickwindow: click a window, screenshot it and upload
 		c/clipboard: upload clipboard contents
-		r/record: record screen region and upload
+		r/record: record screen region and upload, or stop the recording
+		          that is already running
 		u/url: shorten url
 	site: name of the target site
 	silent: disables all console output except errors if enabled
@@ -554,7 +1539,7 @@ func ShareNix(cfg *Config, mode, site string, silent,
 	url, thumburl, deleteurl string, err error) {
 
 	var sitecfg *SiteConfig
-	var res *http.Response
+	var res *Response
 	var filename string
 
 	// initial upload mode check
@@ -587,13 +1572,26 @@ func ShareNix(cfg *Config, mode, site string, silent,
 			err = errors.New("No file provided")
 			return
 		}
-		if err = ArchiveFile(flag.Args()[0]); err != nil {
+		file := flag.Args()[0]
+		if err = ArchiveFile(file); err != nil {
+			return
+		}
+		var process bool
+		process, err = canProcessFile(cfg, file)
+		if err != nil {
 			return
 		}
+		if process {
+			// the processed image is uploaded instead of the original
+			file, err = ArchiveImageFile(cfg, file)
+			if err != nil {
+				return
+			}
+		}
 		if !upload {
 			return
 		}
-		res, filename, sitecfg, err = UploadFile(cfg, sitecfg, flag.Args()[0],
+		res, filename, sitecfg, err = UploadFile(cfg, sitecfg, file,
 			silent, notification, upload)
 
 	case "fs", "fullscreen":
@@ -614,65 +1612,54 @@ func ShareNix(cfg *Config, mode, site string, silent,
 		filename = flag.Args()[0]
 
 	case "s", "section":
-		err = &NotImplementedError{}
-	}
-
-	if err != nil {
-		return
-	}
-
-	if res == nil {
-		err = fmt.Errorf("Request failed, but I don't know why!")
-		return
-	}
+		res, filename, sitecfg, err = UploadSection(cfg, sitecfg, silent,
+			notification, upload)
 
-	switch sitecfg.ResponseType {
-	case "RedirectionURL":
-		DebugPrintln("Getting redirection url...")
-		url = res.Request.URL.String()
-	case "", "Text":
-		// parse response
-		DebugPrintln("Parsing response...")
-		rbody := &bytes.Buffer{}
-		_, err = rbody.ReadFrom(res.Body)
+	case "r", "record":
+		var stopped bool
+		stopped, err = StopRecording()
 		if err != nil {
 			return
 		}
-
-		DebugPrintln(rbody)
-
-		// parse all regular expressions
-		var results [][]string
-		results, err = ParseRegexList(rbody.String(), sitecfg.RegexList)
-		if err != nil {
+		if stopped {
+			Println(silent, "Stopped the running recording")
 			return
 		}
+		res, filename, sitecfg, err = UploadRecording(cfg, sitecfg, silent,
+			notification, upload)
 
-		// replace regular expressions and other tags in urls
-		url = ParseUrl(rbody.Bytes(), sitecfg.URL, results)
-		thumburl = ParseUrl(rbody.Bytes(), sitecfg.ThumbnailURL, results)
-		deleteurl = ParseUrl(rbody.Bytes(), sitecfg.DeletionURL, results)
+	case "w", "window", "pw", "pickwindow":
+		pick := mode == "pw" || mode == "pickwindow"
+		res, filename, sitecfg, err = UploadWindow(cfg, sitecfg, pick, silent,
+			notification, upload)
+	}
 
-		// empty url = take entire response as url
-		if len(url) == 0 {
-			url = rbody.String()
+	if err == nil && res == nil && !upload {
+		// nothing was sent, the capture is only saved in the archive
+		if filename != "" {
+			Println(silent, "Saved to", filename)
 		}
-	default:
-		url = "Unrecognized ResponseType"
+		return
 	}
 
-	fakeResponseEnd()
+	if err != nil {
+		// failed requests are reported like a failed upload
+		var reqerr *RequestError
+		if errors.As(err, &reqerr) {
+			err = requestFailure(sitecfg, res, filename, err)
+			if notification {
+				notifyError(cfg, err)
+			}
+		}
+		return
+	}
 
-	url = strings.TrimSuffix(url, "\n")
-	matchedUrls := xurls.Strict().FindAllString(url, -1)
-	urli := xurls.Strict().FindIndex([]byte(url))
-	if len(matchedUrls) == 1 && urli[0] == 0 &&
-		len(url) == len(matchedUrls[0]) {
-		// the result must only contain an url with no extra stuff to be
-		// considered a valid response
-		AppendToHistory(url, thumburl, deleteurl, filename)
-	} else {
-		err = fmt.Errorf("Request failed: %s", url)
+	url, thumburl, deleteurl, err = ParseResponse(sitecfg, res, filename)
+	if err != nil {
+		if notification {
+			notifyError(cfg, err)
+		}
+		return
 	}
 
 	if copyurl {
@@ -680,7 +1667,7 @@ func ShareNix(cfg *Config, mode, site string, silent,
 		SetClipboardText(url)
 	}
 
-	if open && err == nil {
+	if open {
 		err = exec.Command("xdg-open", url).Run()
 		if err != nil {
 			DebugPrintln(err)
@@ -688,35 +1675,15 @@ func ShareNix(cfg *Config, mode, site string, silent,
 		}
 	}
 
-	// display results
-	if !silent {
-		fmt.Printf("URL: ")
-	}
-	fmt.Println(url)
-	if len(thumburl) > 0 {
-		Println(silent, "Thumbnail URL:", thumburl)
-	}
-	if len(deleteurl) > 0 {
-		Println(silent, "Deletion URL:", deleteurl)
-	}
+	printResult(silent, url, thumburl, deleteurl)
 
 	if notification {
-		if err != nil {
-			if cfg.NotifyCommand != "" {
-				exec.Command(cfg.NotifyCommand, err.Error()).Run()
-			} else {
-				Notifyf(cfg.XineramaHead,
-					time.Second*time.Duration(cfg.NotificationTime), nil,
-					html.EscapeString(err.Error()))
-			}
+		if cfg.NotifyCommand != "" {
+			exec.Command(cfg.NotifyCommand, url).Run()
 		} else {
-			if cfg.NotifyCommand != "" {
-				exec.Command(cfg.NotifyCommand, url).Run()
-			} else {
-				Notifyf(cfg.XineramaHead,
-					time.Second*time.Duration(cfg.NotificationTime), nil,
-					`<a href="%s">%s</a>`, url, url)
-			}
+			Notifyf(cfg.XineramaHead,
+				time.Second*time.Duration(cfg.NotificationTime), nil,
+				`<a href="%s">%s</a>`, url, url)
 		}
 	} else if copyurl {
 		DebugPrintln("Waiting for clipboard manager, feel free to",
@@ -0,0 +1,77 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"archive/zip"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestUploadCapturesArchiveOnly(t *testing.T) {
+	withStorage(t)
+
+	colors := []color.RGBA{red, green, white}
+	for _, bundle := range []bool{false, true} {
+		cfg := &Config{CaptureCount: len(colors), BundleCaptures: bundle}
+		i := 0
+		capture := func() (*image.RGBA, error) {
+			img := solid(4, 4, colors[i])
+			i++
+			return img, nil
+		}
+
+		_, file, _, err := UploadCaptures(cfg, nil, capture, true, false,
+			false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if bundle {
+			if path.Ext(file) != ".zip" {
+				t.Fatalf("got %q, want the zip file", file)
+			}
+			z, err := zip.OpenReader(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(z.File) != len(colors) {
+				t.Errorf("the zip file has %d files, want %d", len(z.File),
+					len(colors))
+			}
+			z.Close()
+			continue
+		}
+
+		// the last capture, like when uploading
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := color.RGBAModel.Convert(img.At(0, 0)); got != white {
+			t.Errorf("%s is %v, want the last capture", file, got)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+//go:build !linux || !(amd64 || arm || arm64 || loong64 || mips64 || mips64le || riscv64)
+// +build !linux !amd64,!arm,!arm64,!loong64,!mips64,!mips64le,!riscv64
+
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import "errors"
+
+// the syscall package doesn't expose the SysV shared memory calls on this
+// platform, so captures always fall back to plain GetImage
+
+var errNoShm = errors.New("SysV shared memory is not supported on this " +
+	"platform")
+
+func shmCreate(size int) (id int, data []byte, err error) {
+	err = errNoShm
+	return
+}
+
+func shmRemove(id int) error {
+	return errNoShm
+}
+
+func shmDetach(data []byte) error {
+	return errNoShm
+}
@@ -0,0 +1,76 @@
+//go:build linux && (amd64 || arm || arm64 || loong64 || mips64 || mips64le || riscv64)
+// +build linux
+// +build amd64 arm arm64 loong64 mips64 mips64le riscv64
+
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"reflect"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	ipcPrivate = 0
+	ipcCreat   = 01000
+	ipcRmid    = 0
+)
+
+// shmCreate creates and attaches a private SysV shared memory segment
+func shmCreate(size int) (id int, data []byte, err error) {
+	rid, _, errno := syscall.Syscall(syscall.SYS_SHMGET, ipcPrivate,
+		uintptr(size), ipcCreat|0600)
+	if errno != 0 {
+		err = errno
+		return
+	}
+	id = int(rid)
+
+	addr, _, errno := syscall.Syscall(syscall.SYS_SHMAT, rid, 0, 0)
+	if errno != 0 {
+		err = errno
+		shmRemove(id)
+		return
+	}
+
+	hdr := (*reflect.SliceHeader)(unsafe.Pointer(&data))
+	hdr.Data = addr
+	hdr.Len = size
+	hdr.Cap = size
+	return
+}
+
+// shmRemove marks a segment for deletion. it will be freed once every
+// process (including the X server) detaches from it
+func shmRemove(id int) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_SHMCTL, uintptr(id), ipcRmid, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// shmDetach detaches a segment created by shmCreate from our address space
+func shmDetach(data []byte) error {
+	hdr := (*reflect.SliceHeader)(unsafe.Pointer(&data))
+	_, _, errno := syscall.Syscall(syscall.SYS_SHMDT, hdr.Data, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
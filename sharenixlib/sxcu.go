@@ -0,0 +1,504 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	neturl "net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sxcuVersion is the ShareX version written in exported files. it tells
+// ShareX to use the {...} syntax
+const sxcuVersion = "13.7.0"
+
+// An SXCU is a ShareX custom uploader, as stored in .sxcu files
+type SXCU struct {
+	Version         string            `json:",omitempty"`
+	Name            string            `json:",omitempty"`
+	DestinationType string            `json:",omitempty"`
+	RequestMethod   string            `json:",omitempty"`
+	RequestURL      string            `json:",omitempty"`
+	Parameters      map[string]string `json:",omitempty"`
+	Headers         map[string]string `json:",omitempty"`
+	Body            string            `json:",omitempty"`
+	Arguments       map[string]string `json:",omitempty"`
+	FileFormName    string            `json:",omitempty"`
+	Data            string            `json:",omitempty"`
+	RegexList       []string          `json:",omitempty"`
+	URL             string            `json:",omitempty"`
+	ThumbnailURL    string            `json:",omitempty"`
+	DeletionURL     string            `json:",omitempty"`
+	ErrorMessage    string            `json:",omitempty"`
+
+	// files made by ShareX 12 and older use these instead of
+	// RequestMethod and the {response} and {responseurl} syntax
+	RequestType  string `json:",omitempty"`
+	ResponseType string `json:",omitempty"`
+}
+
+// sxcuFields lists the fields of .sxcu files that can be imported
+var sxcuFields = map[string]bool{
+	"Version": true, "Name": true, "DestinationType": true,
+	"RequestMethod": true, "RequestURL": true, "Parameters": true,
+	"Headers": true, "Body": true, "Arguments": true, "FileFormName": true,
+	"Data": true, "RegexList": true, "URL": true, "ThumbnailURL": true,
+	"DeletionURL": true, "ErrorMessage": true, "RequestType": true,
+	"ResponseType": true,
+}
+
+// sxcuDestinations maps ShareX destination types to the config setting that
+// holds the default site for them
+var sxcuDestinations = map[string]string{
+	"ImageUploader": "DefaultImageUploader",
+	"FileUploader":  "DefaultFileUploader",
+	"URLShortener":  "DefaultUrlShortener",
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+// unknownKeywords lists the {...} keywords in nodes and their arguments
+// that sharenix doesn't know
+func unknownKeywords(nodes []templateNode) (res []string) {
+	for _, node := range nodes {
+		if node.name == "" || node.legacy {
+			continue
+		}
+		if !templateKeywords[node.name] {
+			res = append(res, node.raw)
+		}
+		for _, arg := range node.args {
+			res = append(res, unknownKeywords(arg)...)
+		}
+	}
+	return
+}
+
+// importSyntax prepares a template in the {...} syntax of ShareX 13 and
+// newer for sharenix, which also reads $...$ keywords. Keywords that
+// sharenix doesn't support are copied as they are and listed in unsupported
+func importSyntax(s string) (res string, unsupported []string) {
+	var buf bytes.Buffer
+
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s):
+			buf.WriteString(s[i : i+2])
+			i++
+
+		case s[i] == '$':
+			// sharenix would read it as the start of a keyword
+			buf.WriteString("\\$")
+
+		default:
+			buf.WriteByte(s[i])
+		}
+	}
+
+	res = buf.String()
+	nodes, err := parseTemplate(res)
+	if err != nil {
+		unsupported = append(unsupported, err.Error())
+		return
+	}
+	unsupported = unknownKeywords(nodes)
+	return
+}
+
+// exportKeyword converts the contents of a sharenix $...$ keyword to the
+// equivalent ShareX {...} keyword
+func exportKeyword(token string) (res string, ok bool) {
+	lower := strings.ToLower(token)
+
+	switch {
+	case lower == "input", lower == "filename", lower == "responseurl":
+		return "{" + lower + "}", true
+
+	case strings.HasPrefix(lower, "header:"):
+		return "{header:" + token[len("header:"):] + "}", true
+
+	case strings.HasPrefix(lower, "json:"), strings.HasPrefix(lower, "xml:"):
+		i := strings.IndexByte(token, ':')
+		return "{" + lower[:i] + token[i:] + "}", true
+
+	case strings.HasPrefix(lower, "regex:"):
+		token = token[len("regex:"):]
+	}
+
+	// $n$ or $n,group$. ShareX can't pick a match other than the first
+	parts := strings.Split(token, ",")
+	if len(parts) > 2 || !isNumber(strings.TrimSpace(parts[0])) {
+		return "", false
+	}
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	return "{regex:" + strings.Join(parts, "|") + "}", true
+}
+
+// sharenix keywords in the %xx format, ShareX has no equivalent
+var percentKeywords = []string{"%yy", "%mo", "%d", "%h", "%mi", "%s"}
+
+// exportSyntax converts sharenix's $...$ syntax to the {...} syntax of
+// ShareX 13 and newer, {...} keywords are copied as they are. Keywords that
+// ShareX doesn't support are copied as they are and listed in unsupported
+func exportSyntax(s string) (res string, unsupported []string) {
+	var buf bytes.Buffer
+
+	for _, keyword := range percentKeywords {
+		if strings.Contains(s, keyword) {
+			unsupported = append(unsupported, keyword)
+		}
+	}
+
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '$':
+			end := strings.IndexByte(s[i+1:], '$')
+			if end < 0 {
+				buf.WriteString(s[i:])
+				return buf.String(), unsupported
+			}
+
+			token := s[i+1 : i+1+end]
+			keyword, ok := exportKeyword(token)
+			if !ok {
+				unsupported = append(unsupported, "$"+token+"$")
+				keyword = "$" + token + "$"
+			}
+			buf.WriteString(keyword)
+			i += end + 1
+
+		case s[i] == '\\' && i+1 < len(s):
+			// escapes are the same in ShareX
+			buf.WriteString(s[i : i+2])
+			i++
+
+		default:
+			buf.WriteByte(s[i])
+		}
+	}
+
+	return buf.String(), unsupported
+}
+
+// sortedKeys returns the keys of m in alphabetical order
+func sortedKeys(m map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ImportSXCU converts a ShareX custom uploader to a site config.
+// defaults lists the config settings (such as DefaultImageUploader) that
+// match its destination types. unsupported describes the fields and
+// keywords that sharenix can't handle, the site might not work without them
+func ImportSXCU(data []byte) (sitecfg *SiteConfig, defaults,
+	unsupported []string, err error) {
+
+	// ShareX writes a byte order mark
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(data, &fields); err != nil {
+		err = fmt.Errorf("Invalid sxcu file: %v", err)
+		return
+	}
+	for _, name := range sortedKeys(fields) {
+		if !sxcuFields[name] {
+			unsupported = append(unsupported, name)
+		}
+	}
+
+	var sx SXCU
+	if err = json.Unmarshal(data, &sx); err != nil {
+		err = fmt.Errorf("Invalid sxcu file: %v", err)
+		return
+	}
+
+	// files without a version come from ShareX 12 or older, which used
+	// the same $...$ syntax as sharenix
+	legacy := sx.Version == ""
+
+	// the whole response or the final url after redirects
+	responseType := sx.ResponseType
+	if !legacy {
+		switch strings.ToLower(sx.URL) {
+		case "{response}":
+			sx.URL = ""
+		case "{responseurl}":
+			sx.URL = ""
+			responseType = "RedirectionURL"
+		}
+	}
+
+	convert := func(field, s string) string {
+		if legacy {
+			return s
+		}
+		res, bad := importSyntax(s)
+		for _, b := range bad {
+			unsupported = append(unsupported, field+": "+b)
+		}
+		return res
+	}
+	convertMap := func(field string, m map[string]string) map[string]string {
+		if m == nil {
+			return nil
+		}
+		res := make(map[string]string, len(m))
+		for k, v := range m {
+			res[k] = convert(field+"."+k, v)
+		}
+		return res
+	}
+
+	sitecfg = &SiteConfig{
+		Name:         sx.Name,
+		RequestType:  strings.ToUpper(sx.RequestMethod),
+		RequestURL:   convert("RequestURL", sx.RequestURL),
+		Headers:      convertMap("Headers", sx.Headers),
+		Arguments:    convertMap("Arguments", sx.Arguments),
+		FileFormName: sx.FileFormName,
+		Data:         convert("Data", sx.Data),
+		RegexList:    sx.RegexList,
+		ResponseType: responseType,
+		URL:          convert("URL", sx.URL),
+		ThumbnailURL: convert("ThumbnailURL", sx.ThumbnailURL),
+		DeletionURL:  convert("DeletionURL", sx.DeletionURL),
+		ErrorMessage: convert("ErrorMessage", sx.ErrorMessage),
+	}
+
+	if sitecfg.RequestType == "" {
+		sitecfg.RequestType = strings.ToUpper(sx.RequestType)
+	}
+	if sitecfg.RequestType == "" {
+		sitecfg.RequestType = "POST"
+	}
+
+	if sitecfg.Name == "" {
+		u, perr := neturl.Parse(sitecfg.RequestURL)
+		if perr != nil || u.Host == "" {
+			err = fmt.Errorf("The uploader has no name and no valid url")
+			return
+		}
+		sitecfg.Name = u.Host
+	}
+
+	if !legacy {
+		// ShareX 13 sends no body unless told otherwise
+		sitecfg.Body = sx.Body
+		if sitecfg.Body == "" {
+			sitecfg.Body = BodyNone
+		}
+	}
+
+	switch sitecfg.ResponseType {
+	case "":
+		sitecfg.ResponseType = "Text"
+	case "LocationHeader":
+		// an empty URL is the Location header in sharenix
+		sitecfg.ResponseType = "Headers"
+	case "Text", "RedirectionURL":
+	default:
+		unsupported = append(unsupported, "ResponseType: "+
+			sitecfg.ResponseType)
+	}
+
+	// Parameters always go in the query string
+	sitecfg.Parameters = convertMap("Parameters", sx.Parameters)
+
+	for _, dest := range strings.Split(sx.DestinationType, ",") {
+		dest = strings.TrimSpace(dest)
+		if setting, ok := sxcuDestinations[dest]; ok {
+			defaults = append(defaults, setting)
+		} else if dest != "" && dest != "None" {
+			unsupported = append(unsupported, "DestinationType: "+dest)
+		}
+	}
+
+	return
+}
+
+// ImportSXCUFile reads a .sxcu file and converts it with ImportSXCU
+func ImportSXCUFile(path string) (sitecfg *SiteConfig, defaults,
+	unsupported []string, err error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	return ImportSXCU(data)
+}
+
+// ExportSXCU converts a site config to a ShareX custom uploader.
+// unsupported describes the settings and keywords that ShareX can't handle.
+// Sites whose Arguments end up in the query string fail if one of them has
+// the same name as a different parameter
+func (cfg *Config) ExportSXCU(sitecfg *SiteConfig) (sx *SXCU,
+	unsupported []string, err error) {
+
+	if sitecfg.RequestType == "PLUGIN" {
+		err = fmt.Errorf("%s is a plugin and can't be exported",
+			sitecfg.Name)
+		return
+	}
+
+	convert := func(field, s string) string {
+		res, bad := exportSyntax(s)
+		for _, b := range bad {
+			unsupported = append(unsupported, field+": "+b)
+		}
+		return res
+	}
+	convertMap := func(field string, m map[string]string) map[string]string {
+		if len(m) == 0 {
+			return nil
+		}
+		res := make(map[string]string, len(m))
+		for k, v := range m {
+			res[k] = convert(field+"."+k, v)
+		}
+		return res
+	}
+
+	sx = &SXCU{
+		Version:       sxcuVersion,
+		Name:          sitecfg.Name,
+		RequestMethod: sitecfg.RequestType,
+		RequestURL:    convert("RequestURL", sitecfg.RequestURL),
+		Headers:       convertMap("Headers", sitecfg.Headers),
+		FileFormName:  sitecfg.FileFormName,
+		Data:          convert("Data", sitecfg.Data),
+		RegexList:     sitecfg.RegexList,
+		URL:           convert("URL", sitecfg.URL),
+		ThumbnailURL:  convert("ThumbnailURL", sitecfg.ThumbnailURL),
+		DeletionURL:   convert("DeletionURL", sitecfg.DeletionURL),
+		ErrorMessage:  convert("ErrorMessage", sitecfg.ErrorMessage),
+	}
+
+	// sites without Body pick it from the method
+	sx.Body = sitecfg.Body
+	if sx.Body == "" {
+		switch {
+		case sitecfg.RequestType == "PUT":
+			sx.Body = BodyBinary
+		case sitecfg.RequestType == "GET":
+			sx.Body = BodyNone
+		default:
+			sx.Body = BodyMultipart
+		}
+	}
+
+	// ShareX only sends Arguments in the body, sharenix puts them in the
+	// query string when the body has no room for them
+	sx.Parameters = convertMap("Parameters", sitecfg.Parameters)
+	args := convertMap("Arguments", sitecfg.Arguments)
+	switch sx.Body {
+	case BodyMultipart, BodyFormURLEncoded:
+		sx.Arguments = args
+	default:
+		if len(args) > 0 && sx.Parameters == nil {
+			sx.Parameters = make(map[string]string, len(args))
+		}
+		for k, v := range args {
+			if p, ok := sx.Parameters[k]; ok && p != v {
+				err = fmt.Errorf("%s has both an argument and a parameter "+
+					"named %q, which would both go in the query string",
+					sitecfg.Name, k)
+				return
+			}
+			sx.Parameters[k] = v
+		}
+	}
+
+	switch sitecfg.ResponseType {
+	case "RedirectionURL":
+		sx.URL = "{responseurl}"
+	case "", "Text":
+		if sx.URL == "" {
+			sx.URL = "{response}"
+		}
+	case "Headers":
+		if sx.URL == "" {
+			sx.URL = "{header:Location}"
+		}
+	default:
+		unsupported = append(unsupported, "ResponseType: "+
+			sitecfg.ResponseType)
+	}
+
+	// ShareX has no basic auth setting, but the header does the same
+	if sitecfg.Username != "" {
+		if sx.Headers == nil {
+			sx.Headers = make(map[string]string)
+		}
+		sx.Headers["Authorization"] = "Basic " +
+			base64.StdEncoding.EncodeToString(
+				[]byte(sitecfg.Username+":"+sitecfg.Password))
+	}
+
+	if sitecfg.ThumbnailFormName != "" {
+		unsupported = append(unsupported, "ThumbnailFormName")
+	}
+	if sitecfg.Timeout != 0 {
+		unsupported = append(unsupported, "Timeout")
+	}
+	if sitecfg.Retries != 0 || len(sitecfg.RetryOn) != 0 {
+		unsupported = append(unsupported, "Retries")
+	}
+	if len(sitecfg.SuccessStatus) != 0 {
+		unsupported = append(unsupported, "SuccessStatus")
+	}
+	if sitecfg.SuccessCondition != "" {
+		unsupported = append(unsupported, "SuccessCondition")
+	}
+
+	// the destination types this site is the default for, otherwise a
+	// guess based on whether it takes a file
+	var dests []string
+	if cfg.DefaultImageUploader == sitecfg.Name {
+		dests = append(dests, "ImageUploader")
+	}
+	if cfg.DefaultFileUploader == sitecfg.Name {
+		dests = append(dests, "FileUploader")
+	}
+	if cfg.DefaultUrlShortener == sitecfg.Name {
+		dests = append(dests, "URLShortener")
+	}
+	if len(dests) == 0 {
+		if sitecfg.FileFormName != "" || sx.Body == BodyBinary {
+			dests = []string{"ImageUploader", "FileUploader"}
+		} else {
+			dests = []string{"URLShortener"}
+		}
+	}
+	sx.DestinationType = strings.Join(dests, ", ")
+
+	return
+}
@@ -0,0 +1,270 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"encoding/json"
+	neturl "net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestImportSXCUParameters(t *testing.T) {
+	oldPrompt := PromptFunc
+	PromptFunc = func(title, def string) (string, error) {
+		return "k&y=1", nil
+	}
+	defer func() { PromptFunc = oldPrompt }()
+
+	tests := []struct {
+		name, sxcu, input, want string
+	}{
+		{"is.gd", `{
+			"Version": "13.1.0",
+			"Name": "is.gd",
+			"DestinationType": "URLShortener",
+			"RequestMethod": "GET",
+			"RequestURL": "https://is.gd/create.php",
+			"Parameters": {
+				"format": "simple",
+				"url": "{input}"
+			},
+			"URL": "{response}"
+		}`, "https://example.com/a b?c=d&e",
+			"https://is.gd/create.php?format=simple&" +
+				"url=https%3A%2F%2Fexample.com%2Fa+b%3Fc%3Dd%26e"},
+
+		{"uguu.se", `{
+			"Version": "13.5.0",
+			"Name": "uguu.se",
+			"DestinationType": "ImageUploader, TextUploader, FileUploader",
+			"RequestMethod": "POST",
+			"RequestURL": "https://uguu.se/upload.php",
+			"Parameters": {
+				"output": "text"
+			},
+			"Body": "MultipartFormData",
+			"FileFormName": "files[]",
+			"URL": "{response}"
+		}`, "a.png", "https://uguu.se/upload.php?output=text"},
+
+		{"api key prompt", `{
+			"Version": "13.7.0",
+			"Name": "example.com",
+			"RequestMethod": "POST",
+			"RequestURL": "https://example.com/api/upload?v=2",
+			"Parameters": {
+				"key": "{inputbox:API key}",
+				"name": "{filename}"
+			},
+			"Body": "MultipartFormData",
+			"FileFormName": "file",
+			"URL": "{json:data.link}"
+		}`, "my photo.png",
+			"https://example.com/api/upload?v=2&key=k%26y%3D1&" +
+				"name=my+photo.png"},
+
+		{"dollar and base64", `{
+			"Version": "13.7.0",
+			"Name": "example.com",
+			"RequestMethod": "POST",
+			"RequestURL": "https://example.com/upload",
+			"Parameters": {
+				"auth": "{base64:me}",
+				"price": "$5 \\{literal\\}"
+			},
+			"Body": "Binary",
+			"URL": "{response}"
+		}`, "a.png",
+			"https://example.com/upload?auth=bWU%3D&" +
+				"price=%245+%7Bliteral%7D"},
+	}
+
+	for _, test := range tests {
+		sitecfg, _, unsupported, err := ImportSXCU([]byte(test.sxcu))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		for _, u := range unsupported {
+			if strings.HasPrefix(u, "Parameters") {
+				t.Errorf("%s: unsupported %s", test.name, u)
+			}
+		}
+
+		req := sitecfg.Clone()
+		if err = ReplaceKeywords(test.input, "png", req); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if req.RequestURL != test.want {
+			t.Errorf("%s: the url is\n%s\nwant\n%s", test.name,
+				req.RequestURL, test.want)
+		}
+		if req.Parameters != nil {
+			t.Errorf("%s: the parameters were left in the site",
+				test.name)
+		}
+	}
+}
+
+func TestExportSXCUParameters(t *testing.T) {
+	cfg := &Config{}
+	sitecfg := &SiteConfig{
+		Name:        "is.gd",
+		RequestType: "GET",
+		RequestURL:  "https://is.gd/create.php",
+		Parameters:  map[string]string{"url": "$input$"},
+		Arguments:   map[string]string{"format": "simple"},
+	}
+
+	sx, _, err := cfg.ExportSXCU(sitecfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sx.Parameters["url"] != "{input}" ||
+		sx.Parameters["format"] != "simple" || len(sx.Arguments) != 0 {
+		t.Errorf("got Parameters %v and Arguments %v", sx.Parameters,
+			sx.Arguments)
+	}
+	if sitecfg.Parameters["format"] != "" {
+		t.Error("ExportSXCU modified the site")
+	}
+}
+
+func TestExportSXCUParameterCollision(t *testing.T) {
+	sitecfg := &SiteConfig{
+		Name:        "example.com",
+		RequestType: "POST",
+		RequestURL:  "https://example.com/upload",
+		Body:        BodyBinary,
+		Parameters:  map[string]string{"key": "a", "name": "$filename$"},
+		Arguments:   map[string]string{"key": "b"},
+	}
+	if sx, _, err := (&Config{}).ExportSXCU(sitecfg); err == nil {
+		t.Errorf("got Parameters %v, want an error", sx.Parameters)
+	}
+
+	// multipart bodies have room for both
+	sitecfg.Body = BodyMultipart
+	sx, _, err := (&Config{}).ExportSXCU(sitecfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sx.Parameters["key"] != "a" || sx.Arguments["key"] != "b" {
+		t.Errorf("got Parameters %v and Arguments %v", sx.Parameters,
+			sx.Arguments)
+	}
+}
+
+func TestExportSXCURoundTrip(t *testing.T) {
+	tests := []*SiteConfig{
+		{
+			Name:        "is.gd",
+			RequestType: "GET",
+			RequestURL:  "https://is.gd/create.php",
+			Parameters:  map[string]string{"url": "$input$"},
+			Arguments:   map[string]string{"format": "simple"},
+			URL:         "$response$",
+		},
+		{
+			Name:         "example.com",
+			RequestType:  "POST",
+			RequestURL:   "https://example.com/upload",
+			Body:         BodyMultipart,
+			FileFormName: "file",
+			Parameters:   map[string]string{"key": "secret"},
+			Arguments:    map[string]string{"key": "form", "a": "$filename$"},
+			Headers:      map[string]string{"X-Name": "{filename}"},
+			URL:          "{json:data.link}",
+		},
+		{
+			Name:        "example.org",
+			RequestType: "PUT",
+			RequestURL:  "https://example.org/$filename$?v=2",
+			Arguments:   map[string]string{"key": "k"},
+			Parameters:  map[string]string{"name": "$filename$"},
+			URL:         "$responseurl$",
+		},
+	}
+
+	for _, sitecfg := range tests {
+		sx, _, err := (&Config{}).ExportSXCU(sitecfg)
+		if err != nil {
+			t.Errorf("%s: %v", sitecfg.Name, err)
+			continue
+		}
+		data, err := json.Marshal(sx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		imported, _, _, err := ImportSXCU(data)
+		if err != nil {
+			t.Errorf("%s: %v", sitecfg.Name, err)
+			continue
+		}
+
+		// both send the same request. arguments that don't fit in the body
+		// are sent in the query string
+		form := sx.Body == BodyMultipart || sx.Body == BodyFormURLEncoded
+		want, got := sitecfg.Clone(), imported.Clone()
+		for _, s := range []*SiteConfig{want, got} {
+			if err = ReplaceKeywords("a b.png", ".png", s); err != nil {
+				t.Fatal(err)
+			}
+			if !form {
+				s.RequestURL = appendQuery(s.RequestURL, s.Arguments)
+				s.Arguments = nil
+			}
+		}
+		if !sameURL(got.RequestURL, want.RequestURL) {
+			t.Errorf("%s: the url is %s, want %s", sitecfg.Name,
+				got.RequestURL, want.RequestURL)
+		}
+		if !mapsEqual(got.Arguments, want.Arguments) ||
+			!mapsEqual(got.Headers, want.Headers) {
+
+			t.Errorf("%s: got %v and %v, want %v and %v", sitecfg.Name,
+				got.Arguments, got.Headers, want.Arguments, want.Headers)
+		}
+	}
+}
+
+// mapsEqual is true if a and b have the same keys and values. nil and empty
+// maps are equal
+func mapsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// sameURL is true if a and b only differ in the order of the query
+func sameURL(a, b string) bool {
+	ua, erra := neturl.Parse(a)
+	ub, errb := neturl.Parse(b)
+	if erra != nil || errb != nil {
+		return false
+	}
+	return ua.Scheme == ub.Scheme && ua.Host == ub.Host &&
+		ua.Path == ub.Path && reflect.DeepEqual(ua.Query(), ub.Query())
+}
@@ -0,0 +1,614 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Templates mix text with keywords in two syntaxes.
+
+The sharenix (and ShareX 12) syntax, which can't be nested:
+
+	$input$, $filename$: whatever is passed as input
+	$extension$: whatever is passed as extension
+	$thumbnail$: path of the locally generated thumbnail, if any
+	$Y$, $M$, $D$, $h$, $m$, $s$, $n$: local date and time
+	$json:some.json.element$, $xml:/root/some/xml/element$
+	$header:Name$: a response header
+	$status$: the status code of the response
+	$responseurl$: the url of the response, after redirects
+	$regex:n,group,m$, $regex:n,group$, $regex:n$, $n,group$, $n$: group
+	(a number or a name) of the m-th match of regex n of RegexList
+
+The ShareX 13 syntax, where arguments are separated by | and can contain
+other keywords:
+
+	{input}, {filename}: whatever is passed as input
+	{response}: the response body
+	{responseurl}: the url of the response, after redirects
+	{header:Name}: a response header
+	{status}: the status code of the response
+	{json:path}, {json:input|path}: a json value of the response or input
+	{xml:xpath}, {xml:input|xpath}: an xml value of the response or input
+	{regex:n}, {regex:n|group}, {regex:n|group|m}: group (a number or a
+	name) of the m-th match of regex n of RegexList
+	{regex:pattern}, {regex:pattern|group|m}: the same for a pattern that
+	is matched against the response
+	{random:a|b|...}: one of the arguments at random
+	{base64:text}: text encoded as base64
+	{inputbox}, {inputbox:title|default}: asks the user for a value.
+	{prompt:title|default} is the same
+
+A backslash escapes {, }, | and $ as well as itself. In request templates,
+%yy, %mo, %d, %h, %mi and %s are replaced with the date and time too.
+*/
+
+// PromptFunc asks the user for the value of an {inputbox} keyword. It reads
+// a line from the terminal by default, programs that use sharenixlib can
+// replace it
+var PromptFunc = promptTerminal
+
+// A TemplateContext holds the values that template keywords are replaced
+// with
+type TemplateContext struct {
+	Input     string
+	Extension string
+	Thumbnail string
+	Time      time.Time
+
+	// Request is true while the request is being built. Keywords that
+	// depend on the response are left as they are so that they can be
+	// replaced once the response arrives
+	Request bool
+
+	Response     []byte
+	ResponseURL  string
+	Status       int
+	Header       http.Header
+	RegexResults []RegexResult
+
+	// Escape, if set, is applied to the values of keywords but not to the
+	// text around them
+	Escape func(string) string
+}
+
+// A TemplateError is returned when a template can't be parsed or evaluated
+type TemplateError struct {
+	Template string
+	// Pos is the byte offset of the error in Template
+	Pos int
+	// Keyword is the keyword that failed, empty if the template can't be
+	// parsed
+	Keyword string
+	// Input is the text the keyword was evaluated on, such as the response
+	// body, if the error comes from it
+	Input string
+	Err   error
+}
+
+func (e *TemplateError) Error() string {
+	return fmt.Sprintf("%v at position %d of %q", e.Err, e.Pos+1,
+		e.Template)
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+// a templateNode is either text or a keyword
+type templateNode struct {
+	text string
+
+	// keyword name, empty for text
+	name   string
+	legacy bool
+	// arg is the part after the colon of $name:arg$ keywords
+	arg string
+	// args are the arguments of {name:arg|arg} keywords
+	args [][]templateNode
+
+	// position and source of the keyword, which is copied as is when the
+	// keyword can't be replaced yet
+	pos int
+	raw string
+}
+
+// a ShareX 13 keyword starts with a name followed by : or }. other braces
+// are text, json templates are full of them
+var keywordStartRe = regexp.MustCompile(`^\{[a-zA-Z][a-zA-Z0-9]*[:}]`)
+
+// legacy keywords that take an argument after a colon
+var legacyPrefixes = []string{"json", "xml", "regex", "header"}
+
+type templateParser struct {
+	src string
+	pos int
+}
+
+func (p *templateParser) errorf(pos int, format string,
+	a ...interface{}) error {
+
+	return &TemplateError{Template: p.src, Pos: pos,
+		Err: fmt.Errorf(format, a...)}
+}
+
+// parseTemplate splits a template into text and keywords
+func parseTemplate(src string) (nodes []templateNode, err error) {
+	p := &templateParser{src: src}
+	return p.parseSeq(true)
+}
+
+// parseSeq parses text and keywords until the end of the template or, for
+// keyword arguments, until the | or } that ends the argument
+func (p *templateParser) parseSeq(top bool) (nodes []templateNode,
+	err error) {
+
+	var text []byte
+	flush := func() {
+		if len(text) > 0 {
+			nodes = append(nodes, templateNode{text: string(text)})
+			text = nil
+		}
+	}
+
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		rest := p.src[p.pos:]
+
+		switch {
+		case c == '\\' && len(rest) > 1 && strings.IndexByte(`{}|$\`,
+			rest[1]) >= 0:
+			text = append(text, rest[1])
+			p.pos += 2
+
+		case !top && (c == '|' || c == '}'):
+			flush()
+			return
+
+		case c == '{' && keywordStartRe.MatchString(rest):
+			flush()
+			var node templateNode
+			if node, err = p.parseKeyword(); err != nil {
+				return
+			}
+			nodes = append(nodes, node)
+
+		case c == '$' && top:
+			end := strings.IndexByte(rest[1:], '$')
+			if end < 0 {
+				// a lone $ is just text
+				text = append(text, rest...)
+				p.pos = len(p.src)
+				continue
+			}
+			flush()
+			nodes = append(nodes, legacyKeyword(rest[1:1+end], p.pos))
+			p.pos += end + 2
+
+		default:
+			text = append(text, c)
+			p.pos++
+		}
+	}
+
+	if !top {
+		err = p.errorf(p.pos, "Unterminated keyword")
+		return
+	}
+
+	flush()
+	return
+}
+
+// parseKeyword parses a {name} or {name:arg|arg} keyword that starts at the
+// current position
+func (p *templateParser) parseKeyword() (node templateNode, err error) {
+	start := p.pos
+	p.pos++
+
+	end := p.pos
+	for end < len(p.src) && p.src[end] != ':' && p.src[end] != '}' {
+		end++
+	}
+	node.name = strings.ToLower(p.src[p.pos:end])
+	node.pos = start
+	p.pos = end
+
+	if p.src[p.pos] == ':' {
+		for p.pos < len(p.src) && p.src[p.pos] != '}' {
+			// skip the : or |
+			p.pos++
+			var arg []templateNode
+			if arg, err = p.parseSeq(false); err != nil {
+				err = p.errorf(start, "Unterminated {%s keyword", node.name)
+				return
+			}
+			node.args = append(node.args, arg)
+		}
+	}
+
+	// skip the }
+	p.pos++
+	node.raw = p.src[start:p.pos]
+	return
+}
+
+// legacyKeyword creates the node for the contents of a $...$ keyword
+func legacyKeyword(token string, pos int) (node templateNode) {
+	if token == "" {
+		return templateNode{text: "$$"}
+	}
+
+	node = templateNode{legacy: true, name: token, pos: pos,
+		raw: "$" + token + "$"}
+
+	lower := strings.ToLower(token)
+	for _, prefix := range legacyPrefixes {
+		if strings.HasPrefix(lower, prefix+":") {
+			node.name = prefix
+			node.arg = strings.TrimSpace(token[len(prefix)+1:])
+		}
+	}
+
+	return
+}
+
+// percentReplacer replaces the %xx keywords in the text of request templates
+func percentReplacer(t time.Time) *strings.Replacer {
+	return strings.NewReplacer(
+		"%yy", fmt.Sprintf("%04d", t.Year()),
+		"%mo", fmt.Sprintf("%02d", t.Month()),
+		"%mi", fmt.Sprintf("%02d", t.Minute()),
+		"%d", fmt.Sprintf("%02d", t.Day()),
+		"%h", fmt.Sprintf("%02d", t.Hour()),
+		"%s", fmt.Sprintf("%02d", t.Second()),
+	)
+}
+
+// ExpandTemplate replaces the keywords in template with their values
+func ExpandTemplate(template string, ctx *TemplateContext) (res string,
+	err error) {
+
+	nodes, err := parseTemplate(template)
+	if err != nil {
+		return
+	}
+
+	e := &templateEvaluator{src: template, ctx: ctx}
+	if ctx.Request {
+		e.percent = percentReplacer(ctx.Time)
+	}
+
+	res, _, err = e.evalSeq(nodes, true)
+	return
+}
+
+type templateEvaluator struct {
+	src     string
+	ctx     *TemplateContext
+	percent *strings.Replacer
+}
+
+func (e *templateEvaluator) errorf(node *templateNode, format string,
+	a ...interface{}) *TemplateError {
+
+	return e.wrapError(node, nil, fmt.Errorf(format, a...))
+}
+
+// wrapError returns a TemplateError for node that failed on input
+func (e *templateEvaluator) wrapError(node *templateNode, input []byte,
+	err error) *TemplateError {
+
+	return &TemplateError{Template: e.src, Pos: node.pos, Keyword: node.raw,
+		Input: string(input), Err: err}
+}
+
+// evalSeq evaluates a list of nodes. deferred is true if some keywords
+// depend on the response, which isn't available yet
+func (e *templateEvaluator) evalSeq(nodes []templateNode, top bool) (
+	res string, deferred bool, err error) {
+
+	var buf strings.Builder
+	for i := range nodes {
+		node := &nodes[i]
+		if node.name == "" {
+			text := node.text
+			if top && e.percent != nil {
+				text = e.percent.Replace(text)
+			}
+			buf.WriteString(text)
+			continue
+		}
+
+		var value string
+		var later bool
+		if node.legacy {
+			value, later, err = e.evalLegacy(node)
+		} else {
+			value, later, err = e.evalKeyword(node)
+		}
+		if err != nil {
+			return
+		}
+
+		switch {
+		case later:
+			deferred = true
+			buf.WriteString(node.raw)
+		case top && e.ctx.Escape != nil:
+			buf.WriteString(e.ctx.Escape(value))
+		default:
+			buf.WriteString(value)
+		}
+	}
+
+	res = buf.String()
+	return
+}
+
+// evalLegacy evaluates a $...$ keyword
+func (e *templateEvaluator) evalLegacy(node *templateNode) (res string,
+	deferred bool, err error) {
+
+	ctx := e.ctx
+	t := ctx.Time
+
+	switch node.name {
+	case "input", "filename":
+		return ctx.Input, false, nil
+	case "extension":
+		return ctx.Extension, false, nil
+	case "thumbnail":
+		return ctx.Thumbnail, false, nil
+	case "Y":
+		return fmt.Sprintf("%04d", t.Year()), false, nil
+	case "M":
+		return fmt.Sprintf("%02d", t.Month()), false, nil
+	case "D":
+		return fmt.Sprintf("%02d", t.Day()), false, nil
+	case "h":
+		return fmt.Sprintf("%02d", t.Hour()), false, nil
+	case "m":
+		return fmt.Sprintf("%02d", t.Minute()), false, nil
+	case "s":
+		return fmt.Sprintf("%02d", t.Second()), false, nil
+	case "n":
+		return fmt.Sprintf("%d", t.Nanosecond()), false, nil
+	}
+
+	// everything else depends on the response. unknown keywords are left
+	// as they are in requests
+	if ctx.Request {
+		return "", true, nil
+	}
+
+	switch node.name {
+	case "json":
+		res, err = parseJsonSyntax(node.arg, ctx.Response)
+	case "xml":
+		res, err = parseXmlSyntax(node.arg, ctx.Response)
+	case "regex":
+		res, err = parseRegexSyntax(node.arg, ctx.RegexResults)
+	case "header":
+		res = ctx.Header.Get(node.arg)
+	case "status":
+		res = strconv.Itoa(ctx.Status)
+	case "responseurl":
+		res = ctx.ResponseURL
+	default:
+		res, err = parseRegexSyntax(strings.TrimSpace(node.name),
+			ctx.RegexResults)
+	}
+	if err != nil {
+		err = e.wrapError(node, ctx.Response, err)
+	}
+	return
+}
+
+// templateKeywords lists the ShareX 13 keywords that sharenix supports
+var templateKeywords = map[string]bool{
+	"input": true, "filename": true, "response": true, "responseurl": true,
+	"status": true, "header": true, "json": true, "xml": true, "regex": true,
+	"random": true, "base64": true, "inputbox": true, "prompt": true,
+}
+
+// responseKeywords lists the ShareX 13 keywords that need the response
+var responseKeywords = map[string]bool{
+	"response": true, "responseurl": true, "status": true, "header": true,
+	"json": true, "xml": true, "regex": true,
+}
+
+// evalKeyword evaluates a {...} keyword
+func (e *templateEvaluator) evalKeyword(node *templateNode) (res string,
+	deferred bool, err error) {
+
+	ctx := e.ctx
+
+	if ctx.Request && responseKeywords[node.name] {
+		return "", true, nil
+	}
+
+	// arguments are evaluated first, they can contain other keywords
+	args := make([]string, len(node.args))
+	for i, arg := range node.args {
+		var later bool
+		args[i], later, err = e.evalSeq(arg, false)
+		if err != nil {
+			return
+		}
+		deferred = deferred || later
+	}
+	if deferred {
+		return
+	}
+
+	nargs := func(min, max int) error {
+		if len(args) < min || len(args) > max {
+			if min == max {
+				return e.errorf(node, "{%s} takes %d arguments, got %d",
+					node.name, min, len(args))
+			}
+			return e.errorf(node, "{%s} takes %d to %d arguments, got %d",
+				node.name, min, max, len(args))
+		}
+		return nil
+	}
+
+	switch node.name {
+	case "input", "filename":
+		err = nargs(0, 0)
+		res = ctx.Input
+
+	case "response":
+		err = nargs(0, 0)
+		res = string(ctx.Response)
+
+	case "responseurl":
+		err = nargs(0, 0)
+		res = ctx.ResponseURL
+
+	case "status":
+		err = nargs(0, 0)
+		res = strconv.Itoa(ctx.Status)
+
+	case "header":
+		if err = nargs(1, 1); err == nil {
+			res = ctx.Header.Get(args[0])
+		}
+
+	case "json", "xml":
+		if err = nargs(1, 2); err != nil {
+			return
+		}
+		input, path := ctx.Response, args[0]
+		if len(args) == 2 {
+			input, path = []byte(args[0]), args[1]
+		}
+		if node.name == "json" {
+			res, err = parseJsonSyntax(path, input)
+		} else {
+			res, err = parseXmlSyntax(path, input)
+		}
+		if err != nil {
+			err = e.wrapError(node, input, err)
+		}
+
+	case "regex":
+		if err = nargs(1, 3); err != nil {
+			return
+		}
+		res, err = e.evalRegex(node, args)
+
+	case "random":
+		if err = nargs(1, len(args)); err == nil {
+			res = args[rng.Intn(len(args))]
+		}
+
+	case "base64":
+		if err = nargs(1, 1); err == nil {
+			res = base64.StdEncoding.EncodeToString([]byte(args[0]))
+		}
+
+	case "inputbox", "prompt":
+		if err = nargs(0, 2); err != nil {
+			return
+		}
+		title, def := "", ""
+		if len(args) > 0 {
+			title = args[0]
+		}
+		if len(args) > 1 {
+			def = args[1]
+		}
+		if res, err = PromptFunc(title, def); err != nil {
+			err = e.wrapError(node, nil, err)
+		}
+
+	default:
+		err = e.errorf(node, "Unknown keyword {%s}", node.name)
+	}
+
+	return
+}
+
+// evalRegex evaluates {regex:n|group|match} and
+// {regex:pattern|group|match}
+func (e *templateEvaluator) evalRegex(node *templateNode, args []string) (
+	res string, err error) {
+
+	for len(args) < 3 {
+		args = append(args, "")
+	}
+
+	results := e.ctx.RegexResults
+	index := args[0]
+	if _, converr := strconv.Atoi(index); converr != nil {
+		// an inline regex instead of one of RegexList
+		var re *regexp.Regexp
+		re, err = regexp.Compile(index)
+		if err != nil {
+			err = e.errorf(node, "Invalid regex: %v", err)
+			return
+		}
+		results = []RegexResult{MatchRegex(re, string(e.ctx.Response))}
+		index = "1"
+	}
+
+	res, err = selectRegexGroup(results, index, args[1], args[2])
+	if err != nil {
+		err = e.wrapError(node, nil, err)
+	}
+	return
+}
+
+// promptTerminal asks for a value on the terminal
+func promptTerminal(title, def string) (res string, err error) {
+	if !isTerminal(os.Stdin) {
+		err = fmt.Errorf("Can't ask for %q without a terminal", title)
+		return
+	}
+
+	if title == "" {
+		title = "Input"
+	}
+	if def != "" {
+		fmt.Printf("%s [%s]: ", title, def)
+	} else {
+		fmt.Printf("%s: ", title)
+	}
+
+	res, err = bufio.NewReader(os.Stdin).ReadString('\n')
+	res = strings.TrimRight(res, "\r\n")
+	if err != nil && res == "" {
+		return
+	}
+	err = nil
+
+	if res == "" {
+		res = def
+	}
+	return
+}
@@ -0,0 +1,185 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testTemplateContext is the context of an upload of "a b.png" that got a
+// json response
+func testTemplateContext() *TemplateContext {
+	header := make(http.Header)
+	header.Set("X-Id", "abc")
+	return &TemplateContext{
+		Input:        "a b.png",
+		Extension:    ".png",
+		Time:         time.Date(2024, 3, 9, 8, 7, 6, 0, time.UTC),
+		Response:     []byte(`{"files": [{"url": "https://x.io/a"}]}`),
+		ResponseURL:  "https://x.io/upload",
+		Status:       201,
+		Header:       header,
+		RegexResults: testRegexResults(),
+	}
+}
+
+// withPrompt makes {inputbox} return its title and default
+func withPrompt(t *testing.T) {
+	old := PromptFunc
+	PromptFunc = func(title, def string) (string, error) {
+		if title == "fail" {
+			return "", errors.New("no terminal")
+		}
+		return title + "=" + def, nil
+	}
+	t.Cleanup(func() { PromptFunc = old })
+}
+
+func TestExpandTemplate(t *testing.T) {
+	withPrompt(t)
+
+	// most of these come from the examples in the ShareX documentation
+	tests := []struct {
+		template string
+		want     []string
+	}{
+		{"https://x.io/{filename}", []string{"https://x.io/a b.png"}},
+		{"{input}", []string{"a b.png"}},
+		{"{json:files[0].url}", []string{"https://x.io/a"}},
+		{"{responseurl} {status} {header:x-id}",
+			[]string{"https://x.io/upload 201 abc"}},
+
+		// escaping
+		{`\{json:files[0].url\}`, []string{"{json:files[0].url}"}},
+		{`{base64:a\|b}`, []string{"YXxi"}},
+		{`{random:\{|\}}`, []string{"{", "}"}},
+		{`\\{filename}`, []string{`\a b.png`}},
+		{`\$filename\$`, []string{"$filename$"}},
+		{`a\b`, []string{`a\b`}},
+
+		// braces that don't start a keyword are text
+		{`{"url": "{filename}"}`, []string{`{"url": "a b.png"}`}},
+
+		// nested keywords
+		{"{json:{response}|files[0].url}", []string{"https://x.io/a"}},
+		{`{json:\{"a": "{filename}"\}|a}`, []string{"a b.png"}},
+		{"{base64:{filename}}", []string{"YSBiLnBuZw=="}},
+		{"{random:{filename}|{status}}", []string{"a b.png", "201"}},
+
+		{"{xml:<f><url>https://x.io/b</url></f>|/f/url}",
+			[]string{"https://x.io/b"}},
+		{"{random:a|b}", []string{"a", "b"}},
+		{"{random:only}", []string{"only"}},
+		{"Basic {base64:user:pass}", []string{"Basic dXNlcjpwYXNz"}},
+		{"{base64:}", []string{""}},
+		{"{regex:1|id} {regex:1|key|2}", []string{"abc 222"}},
+
+		{"{inputbox}", []string{"="}},
+		{"{inputbox:API key}", []string{"API key="}},
+		{"{inputbox:API key|123}", []string{"API key=123"}},
+		{"{prompt:{filename}|x}", []string{"a b.png=x"}},
+
+		// legacy keywords mixed with new ones
+		{"$json:files[0].url$?name={filename}",
+			[]string{"https://x.io/a?name=a b.png"}},
+		// but only at the top level
+		{"$filename$/{base64:$Y$}", []string{"a b.png/JFkk"}},
+		{"$Y$-$M$-$D$ $h$:$m$:$s$ {status}",
+			[]string{"2024-03-09 08:07:06 201"}},
+		{"$1,id$ {regex:1|id|2}", []string{"abc def"}},
+		{"costs $5", []string{"costs $5"}},
+		{"$$ {input}", []string{"$$ a b.png"}},
+	}
+
+	for _, test := range tests {
+		got, err := ExpandTemplate(test.template, testTemplateContext())
+		if err != nil {
+			t.Errorf("%s: %v", test.template, err)
+			continue
+		}
+		ok := false
+		for _, want := range test.want {
+			ok = ok || got == want
+		}
+		if !ok {
+			t.Errorf("%s = %q, want one of %q", test.template, got,
+				test.want)
+		}
+	}
+}
+
+func TestExpandTemplateRequest(t *testing.T) {
+	ctx := testTemplateContext()
+	ctx.Request = true
+	ctx.Escape = strings.ToUpper
+
+	// keywords that need the response are replaced later
+	got, err := ExpandTemplate("%yy/%mo/{filename}/{json:a}/$json:b$/"+
+		"{base64:{response}}", ctx)
+	want := "2024/03/A B.PNG/{json:a}/$json:b$/{base64:{response}}"
+	if err != nil || got != want {
+		t.Errorf("got %q, %v, want %q", got, err, want)
+	}
+}
+
+func TestExpandTemplateErrors(t *testing.T) {
+	withPrompt(t)
+
+	tests := []struct {
+		template string
+		pos      int
+		keyword  string
+		msg      string
+	}{
+		{"abc{json:a", 3, "", "Unterminated {json keyword"},
+		{"{base64:{filename}", 0, "", "Unterminated {base64 keyword"},
+		{"x {nope}", 2, "{nope}", "Unknown keyword {nope}"},
+		{"{json:{nope}|a}", 6, "{nope}", "Unknown keyword {nope}"},
+		{"ab{filename:x}", 2, "{filename:x}",
+			"{filename} takes 0 arguments, got 1"},
+		{"{base64:a|b}", 0, "{base64:a|b}",
+			"{base64} takes 1 arguments, got 2"},
+		{"{regex:1|id|2|3}", 0, "{regex:1|id|2|3}",
+			"{regex} takes 1 to 3 arguments, got 4"},
+		{"$input$ {json:a.b}", 8, "{json:a.b}", "not found"},
+		{"id $json:a[$", 3, "$json:a[$", "Invalid json path"},
+		{"{xml:<a>|/a}", 0, "{xml:<a>|/a}", "Invalid xml"},
+		{"{regex:a(}", 0, "{regex:a(}", "Invalid regex"},
+		{"key: {inputbox:fail}", 5, "{inputbox:fail}", "no terminal"},
+	}
+
+	for _, test := range tests {
+		res, err := ExpandTemplate(test.template, testTemplateContext())
+		var terr *TemplateError
+		if !errors.As(err, &terr) {
+			t.Errorf("%s gave %q, %v, want a TemplateError", test.template,
+				res, err)
+			continue
+		}
+		if terr.Pos != test.pos || terr.Keyword != test.keyword ||
+			terr.Template != test.template ||
+			!strings.Contains(terr.Err.Error(), test.msg) {
+
+			t.Errorf("%s failed at %d in %q with %q, want %d, %q and %q",
+				test.template, terr.Pos, terr.Keyword, terr.Err, test.pos,
+				test.keyword, test.msg)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// wantsThumbnail returns true if a thumbnail must be generated for uploads
+// to the given site
+func (cfg *Config) wantsThumbnail(sitecfg *SiteConfig) bool {
+	return cfg.Thumbnails || sitecfg.ThumbnailFormName != ""
+}
+
+// attachThumbnail generates a thumbnail for the file at path if the config
+// or the site asks for one and stores its path in sitecfg.
+// A thumbnail that can't be generated doesn't stop the upload, the file is
+// just uploaded without it
+func (cfg *Config) attachThumbnail(sitecfg *SiteConfig, path string,
+	silent bool) {
+
+	if !cfg.wantsThumbnail(sitecfg) {
+		return
+	}
+
+	thumb, err := ArchiveThumbnail(cfg, path)
+	if err != nil {
+		Println(silent, "Uploading without a thumbnail:", err)
+		return
+	}
+	sitecfg.localThumbnail = thumb
+}
+
+// thumbnailFiles returns the extra files for SendRequest that upload the
+// local thumbnail, if the site accepts one
+func (sitecfg *SiteConfig) thumbnailFiles() map[string]string {
+	if sitecfg.ThumbnailFormName == "" || sitecfg.localThumbnail == "" {
+		return nil
+	}
+	return map[string]string{
+		sitecfg.ThumbnailFormName: sitecfg.localThumbnail,
+	}
+}
+
+// thumbnailPath returns where the thumbnail of the file at path is saved.
+// Thumbnails of archived files are saved next to them, other thumbnails get
+// their own archive file name
+func thumbnailPath(path, extension string) (string, error) {
+	archiveDir, err := GetArchiveDir()
+	if err != nil {
+		return "", err
+	}
+
+	if filepath.Dir(path) == filepath.Clean(archiveDir) {
+		return strings.TrimSuffix(path, filepath.Ext(path)) + "_thumb" +
+			extension, nil
+	}
+
+	return GenerateArchivedFilename("_thumb" + extension)
+}
+
+// ArchiveThumbnail saves a thumbnail of the image file at path in the
+// archive. The thumbnail fits in cfg.ThumbnailSize x cfg.ThumbnailSize
+// pixels and is encoded with the configured image format.
+// Returns an empty path if the file is not an image.
+func ArchiveThumbnail(cfg *Config, path string) (thumb string, err error) {
+	mimeType, err := SniffMimeType(path)
+	if err != nil {
+		return
+	}
+
+	// the standard library can't decode bmp
+	if !IsImage(mimeType) || mimeType == "image/bmp" {
+		DebugPrintln("Not generating a thumbnail for", mimeType)
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	img, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return
+	}
+
+	small := Resize(toRGBA(img), cfg.ThumbnailSize, cfg.ThumbnailSize)
+	data, extension, err := EncodeImage(cfg, small)
+	if err != nil {
+		return
+	}
+
+	thumb, err = thumbnailPath(path, extension)
+	if err != nil {
+		return
+	}
+
+	DebugPrintln("Saving", small.Bounds().Size(), "thumbnail to", thumb)
+	err = ioutil.WriteFile(thumb, data, 0644)
+	return
+}
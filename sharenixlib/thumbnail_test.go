@@ -0,0 +1,154 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// thumbnailSize decodes a thumbnail and returns its size
+func thumbnailSize(t *testing.T, file string) image.Point {
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	config, err := png.DecodeConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return image.Pt(config.Width, config.Height)
+}
+
+func TestArchiveThumbnail(t *testing.T) {
+	withStorage(t)
+	archive, err := GetArchiveDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		dir     string
+		size    image.Point
+		want    image.Point
+		archive bool
+	}{
+		{"wide.png", archive, image.Pt(64, 32), image.Pt(16, 8), true},
+		{"tall.png", dir, image.Pt(10, 40), image.Pt(4, 16), false},
+		{"odd.png", dir, image.Pt(33, 20), image.Pt(16, 10), false},
+		// small images aren't enlarged
+		{"small.png", archive, image.Pt(8, 4), image.Pt(8, 4), true},
+	}
+
+	cfg := &Config{ThumbnailSize: 16}
+	for _, test := range tests {
+		file := writeImageFile(t, test.dir, test.name, func(f *os.File) error {
+			return png.Encode(f, solid(test.size.X, test.size.Y, red))
+		})
+
+		thumb, err := ArchiveThumbnail(cfg, file)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got := thumbnailSize(t, thumb); got != test.want {
+			t.Errorf("%s: the thumbnail is %v, want %v", test.name, got,
+				test.want)
+		}
+
+		// archived files get a thumbnail with the same name
+		want := file[:len(file)-len(".png")] + "_thumb.png"
+		if test.archive && thumb != want {
+			t.Errorf("%s: got %s, want %s", test.name, thumb, want)
+		}
+		if !test.archive && path.Dir(thumb) != archive {
+			t.Errorf("%s: got %s, want a file in the archive", test.name,
+				thumb)
+		}
+	}
+
+	text := path.Join(dir, "a.txt")
+	if err = ioutil.WriteFile(text, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if thumb, err := ArchiveThumbnail(cfg, text); thumb != "" || err != nil {
+		t.Errorf("text file: got %q, %v, want no thumbnail", thumb, err)
+	}
+}
+
+func TestAttachThumbnailFailure(t *testing.T) {
+	withStorage(t)
+
+	// looks like a png but doesn't decode
+	broken := path.Join(t.TempDir(), "broken.png")
+	data := append([]byte{}, pngSignature...)
+	err := ioutil.WriteFile(broken, append(data, "garbage"...), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{Thumbnails: true, ThumbnailSize: 16}
+	sitecfg := &SiteConfig{ThumbnailFormName: "thumb"}
+	cfg.attachThumbnail(sitecfg, broken, true)
+	if sitecfg.localThumbnail != "" || sitecfg.thumbnailFiles() != nil {
+		t.Errorf("got thumbnail %q, want none", sitecfg.localThumbnail)
+	}
+}
+
+func TestThumbnailHistory(t *testing.T) {
+	withStorage(t)
+
+	tests := []struct {
+		thumbnailURL, want string
+	}{
+		// the local thumbnail is only recorded when the site has none
+		{"", "/archive/a_thumb.png"},
+		{"https://x.io/t/a.png", "https://x.io/t/a.png"},
+	}
+
+	for _, test := range tests {
+		sitecfg := &SiteConfig{ThumbnailURL: test.thumbnailURL,
+			localThumbnail: "/archive/a_thumb.png"}
+		_, thumburl, _, err := ParseResponse(sitecfg,
+			testResponse(200, "https://x.io/a.png"), "a.png")
+		if err != nil || thumburl != test.thumbnailURL {
+			t.Fatalf("got %q, %v, want %q", thumburl, err,
+				test.thumbnailURL)
+		}
+	}
+
+	history, err := GetUploadHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != len(tests) {
+		t.Fatalf("got %d history records, want %d", len(history),
+			len(tests))
+	}
+	for i, record := range history {
+		if record[1] != tests[i].want {
+			t.Errorf("record %d has thumbnail %q, want %q", i, record[1],
+				tests[i].want)
+		}
+	}
+}
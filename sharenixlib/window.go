@@ -0,0 +1,310 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"errors"
+	"github.com/BurntSushi/xgb"
+	"github.com/BurntSushi/xgb/xproto"
+	"image"
+)
+
+func internAtom(X *xgb.Conn, name string) (atom xproto.Atom, err error) {
+	reply, err := xproto.InternAtom(X, false, uint16(len(name)), name).Reply()
+	if err != nil {
+		return
+	}
+	atom = reply.Atom
+	return
+}
+
+// getCardinals reads a property made of 32-bit values such as CARDINAL or
+// WINDOW. returns nil if the property is not set
+func getCardinals(X *xgb.Conn, win xproto.Window, name string) (
+	values []uint32, err error) {
+
+	atom, err := internAtom(X, name)
+	if err != nil {
+		return
+	}
+
+	reply, err := xproto.GetProperty(X, false, win, atom,
+		xproto.GetPropertyTypeAny, 0, 32).Reply()
+	if err != nil {
+		return
+	}
+
+	if reply.Format != 32 {
+		return
+	}
+
+	for i := 0; i+4 <= len(reply.Value); i += 4 {
+		values = append(values, xgb.Get32(reply.Value[i:]))
+	}
+
+	return
+}
+
+// windowRect returns the bounds of a window in root coordinates, excluding
+// its border
+func windowRect(X *xgb.Conn, root, win xproto.Window) (
+	rect image.Rectangle, err error) {
+
+	geom, err := xproto.GetGeometry(X, xproto.Drawable(win)).Reply()
+	if err != nil {
+		return
+	}
+
+	pos, err := xproto.TranslateCoordinates(X, win, root, 0, 0).Reply()
+	if err != nil {
+		return
+	}
+
+	rect = image.Rect(int(pos.DstX), int(pos.DstY),
+		int(pos.DstX)+int(geom.Width), int(pos.DstY)+int(geom.Height))
+	return
+}
+
+// topLevelWindow walks up the window tree and returns the ancestor of win
+// that is a direct child of the root window. on reparenting window managers
+// this is the frame window
+func topLevelWindow(X *xgb.Conn, win xproto.Window) (
+	top xproto.Window, err error) {
+
+	top = win
+	for {
+		var tree *xproto.QueryTreeReply
+		tree, err = xproto.QueryTree(X, top).Reply()
+		if err != nil {
+			return
+		}
+		if tree.Parent == tree.Root || tree.Parent == 0 {
+			return
+		}
+		top = tree.Parent
+	}
+}
+
+// clientWindow searches win and its children for the first window that has
+// WM_STATE set, which is the actual application window on reparenting window
+// managers. returns win if no such window is found
+func clientWindow(X *xgb.Conn, win xproto.Window) (
+	client xproto.Window, err error) {
+
+	wmState, err := internAtom(X, "WM_STATE")
+	if err != nil {
+		return
+	}
+
+	queue := []xproto.Window{win}
+	for len(queue) > 0 {
+		w := queue[0]
+		queue = queue[1:]
+
+		var prop *xproto.GetPropertyReply
+		prop, err = xproto.GetProperty(X, false, w, wmState,
+			xproto.GetPropertyTypeAny, 0, 0).Reply()
+		if err != nil {
+			return
+		}
+		if prop.Type != 0 {
+			return w, nil
+		}
+
+		var tree *xproto.QueryTreeReply
+		tree, err = xproto.QueryTree(X, w).Reply()
+		if err != nil {
+			return
+		}
+		queue = append(queue, tree.Children...)
+	}
+
+	return win, nil
+}
+
+// frameRect returns the bounds of a client window including the window
+// manager decorations. _NET_FRAME_EXTENTS is used when the window manager
+// sets it, otherwise the top-level frame window is measured
+func frameRect(X *xgb.Conn, root, win xproto.Window) (
+	rect image.Rectangle, err error) {
+
+	rect, err = windowRect(X, root, win)
+	if err != nil {
+		return
+	}
+
+	extents, err := getCardinals(X, win, "_NET_FRAME_EXTENTS")
+	if err != nil {
+		return
+	}
+
+	if len(extents) == 4 {
+		DebugPrintln("_NET_FRAME_EXTENTS:", extents)
+		rect.Min.X -= int(extents[0])
+		rect.Max.X += int(extents[1])
+		rect.Min.Y -= int(extents[2])
+		rect.Max.Y += int(extents[3])
+		return
+	}
+
+	DebugPrintln("_NET_FRAME_EXTENTS not set, using the top-level window")
+	top, err := topLevelWindow(X, win)
+	if err != nil {
+		return
+	}
+
+	return windowRect(X, root, top)
+}
+
+// clipToScreen clips a rectangle to the bounds of the given screen,
+// GetImage fails on areas outside of the root window
+func clipToScreen(rect image.Rectangle, screen *xproto.ScreenInfo) (
+	image.Rectangle, error) {
+
+	rect = rect.Intersect(image.Rect(0, 0,
+		int(screen.WidthInPixels), int(screen.HeightInPixels)))
+	if rect.Empty() {
+		return rect, errors.New("The area is not visible on the screen")
+	}
+	return rect, nil
+}
+
+// ActiveWindowRect returns the bounds of the window that currently has focus
+// according to _NET_ACTIVE_WINDOW, in root coordinates.
+// frame: if true, the window manager decorations are included
+func ActiveWindowRect(X *xgb.Conn, frame bool) (
+	rect image.Rectangle, err error) {
+
+	screen := xproto.Setup(X).DefaultScreen(X)
+	if screen == nil {
+		err = errors.New("No default screen found")
+		return
+	}
+
+	active, err := getCardinals(X, screen.Root, "_NET_ACTIVE_WINDOW")
+	if err != nil {
+		return
+	}
+
+	if len(active) == 0 || active[0] == 0 {
+		err = errors.New("Could not find the active window " +
+			"(does your window manager support _NET_ACTIVE_WINDOW?)")
+		return
+	}
+
+	win := xproto.Window(active[0])
+	DebugPrintln("Active window:", win)
+
+	if frame {
+		rect, err = frameRect(X, screen.Root, win)
+	} else {
+		rect, err = windowRect(X, screen.Root, win)
+	}
+	if err != nil {
+		return
+	}
+
+	DebugPrintln("Window bounds:", rect)
+	return clipToScreen(rect, screen)
+}
+
+// PickWindowRect lets the user click a window and returns its bounds in root
+// coordinates. Escape or right click cancel and return a
+// SelectionCanceledError.
+// frame: if true, the window manager decorations are included
+func PickWindowRect(X *xgb.Conn, frame bool) (
+	rect image.Rectangle, err error) {
+
+	screen := xproto.Setup(X).DefaultScreen(X)
+	if screen == nil {
+		err = errors.New("No default screen found")
+		return
+	}
+
+	keys, err := getKeyMap(X)
+	if err != nil {
+		return
+	}
+
+	cursor, err := createCrosshair(X)
+	if err != nil {
+		return
+	}
+	defer xproto.FreeCursor(X, cursor)
+
+	if err = grabPointer(X, screen.Root, screen.Root, cursor); err != nil {
+		return
+	}
+	defer xproto.UngrabPointer(X, xproto.TimeCurrentTime)
+
+	if err = grabKeyboard(X, screen.Root); err != nil {
+		return
+	}
+	defer xproto.UngrabKeyboard(X, xproto.TimeCurrentTime)
+
+	DebugPrintln("Waiting for a window to be clicked...")
+
+	var top xproto.Window
+	for top == 0 {
+		ev, xerr := X.WaitForEvent()
+		if ev == nil && xerr == nil {
+			err = errors.New("X connection closed while picking a window")
+			return
+		}
+		if xerr != nil {
+			DebugPrintln(xerr)
+			continue
+		}
+
+		switch e := ev.(type) {
+		case xproto.ButtonPressEvent:
+			switch e.Detail {
+			case 1:
+				// clicks on the desktop have no child
+				top = e.Child
+			case 3:
+				err = &SelectionCanceledError{}
+				return
+			}
+
+		case xproto.KeyPressEvent:
+			if keys[e.Detail] == keysymEscape {
+				err = &SelectionCanceledError{}
+				return
+			}
+		}
+	}
+
+	DebugPrintln("Picked top-level window:", top)
+
+	if frame {
+		rect, err = windowRect(X, screen.Root, top)
+	} else {
+		var client xproto.Window
+		client, err = clientWindow(X, top)
+		if err != nil {
+			return
+		}
+		DebugPrintln("Client window:", client)
+		rect, err = windowRect(X, screen.Root, client)
+	}
+	if err != nil {
+		return
+	}
+
+	DebugPrintln("Window bounds:", rect)
+	return clipToScreen(rect, screen)
+}
@@ -0,0 +1,295 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"fmt"
+	"github.com/BurntSushi/xgb"
+	"github.com/BurntSushi/xgb/shm"
+	"github.com/BurntSushi/xgb/xproto"
+	"image"
+	"math/bits"
+	"sync"
+)
+
+// A pixelFormat describes how the pixels of a ZPixmap image returned by the
+// X server are laid out
+type pixelFormat struct {
+	bpp      int  // bits per pixel
+	pad      int  // scanlines are padded to a multiple of this many bits
+	msbFirst bool // byte order of each pixel
+	masks    [3]uint32
+}
+
+// newPixelFormat looks up the pixmap format for the given depth and the rgb
+// masks of the given visual
+func newPixelFormat(setupInfo *xproto.SetupInfo, screen *xproto.ScreenInfo,
+	depth byte, visual xproto.Visualid) (f *pixelFormat, err error) {
+
+	f = &pixelFormat{
+		msbFirst: setupInfo.ImageByteOrder == xproto.ImageOrderMSBFirst,
+	}
+
+	for _, format := range setupInfo.PixmapFormats {
+		if format.Depth == depth {
+			f.bpp = int(format.BitsPerPixel)
+			f.pad = int(format.ScanlinePad)
+			break
+		}
+	}
+	if f.bpp == 0 {
+		err = fmt.Errorf("No pixmap format for depth %d", depth)
+		return
+	}
+
+	found := false
+	for _, d := range screen.AllowedDepths {
+		for _, v := range d.Visuals {
+			if v.VisualId == visual {
+				f.masks = [3]uint32{v.RedMask, v.GreenMask, v.BlueMask}
+				found = true
+			}
+		}
+	}
+	if !found {
+		err = fmt.Errorf("Visual %d not found", visual)
+		return
+	}
+
+	switch f.bpp {
+	case 16, 24, 32:
+	default:
+		err = fmt.Errorf("Unsupported pixel format: %d bpp at depth %d",
+			f.bpp, depth)
+		return
+	}
+
+	DebugPrintf("Pixel format: %d bpp, %d bit pad, msb first: %v, "+
+		"masks: %06x %06x %06x\n", f.bpp, f.pad, f.msbFirst,
+		f.masks[0], f.masks[1], f.masks[2])
+	return
+}
+
+// stride returns the length in bytes of a scanline
+func (f *pixelFormat) stride(width int) int {
+	return (width*f.bpp + f.pad - 1) / f.pad * f.pad / 8
+}
+
+// isBGRX returns true for the common 32-bit little endian format that can be
+// converted by just swapping bytes
+func (f *pixelFormat) isBGRX() bool {
+	return f.bpp == 32 && !f.msbFirst && f.masks[0] == 0xFF0000 &&
+		f.masks[1] == 0xFF00 && f.masks[2] == 0xFF
+}
+
+// decode converts raw ZPixmap data to a new rgba image
+func (f *pixelFormat) decode(data []byte, width, height int) (
+	pic *image.RGBA, err error) {
+
+	stride := f.stride(width)
+	if len(data) < stride*height {
+		err = fmt.Errorf("Expected %d bytes of image data, got %d",
+			stride*height, len(data))
+		return
+	}
+
+	pic = image.NewRGBA(image.Rect(0, 0, width, height))
+
+	if f.isBGRX() {
+		for y := 0; y < height; y++ {
+			src := data[y*stride : y*stride+width*4]
+			dst := pic.Pix[y*pic.Stride : y*pic.Stride+width*4]
+			for i := 0; i < len(src); i += 4 {
+				dst[i] = src[i+2]
+				dst[i+1] = src[i+1]
+				dst[i+2] = src[i]
+				dst[i+3] = 255
+			}
+		}
+		return
+	}
+
+	// generic path for any mask, byte order and pixel size
+	var shifts, maxs [3]uint32
+	for c, mask := range f.masks {
+		shifts[c] = uint32(bits.TrailingZeros32(mask))
+		maxs[c] = mask >> shifts[c]
+		if maxs[c] == 0 {
+			err = fmt.Errorf("Invalid color mask %x", mask)
+			return
+		}
+	}
+
+	bytesPerPixel := f.bpp / 8
+	for y := 0; y < height; y++ {
+		row := data[y*stride:]
+		dst := pic.Pix[y*pic.Stride:]
+		for x := 0; x < width; x++ {
+			px := row[x*bytesPerPixel : x*bytesPerPixel+bytesPerPixel]
+			var p uint32
+			if f.msbFirst {
+				for _, b := range px {
+					p = p<<8 | uint32(b)
+				}
+			} else {
+				for i := len(px) - 1; i >= 0; i-- {
+					p = p<<8 | uint32(px[i])
+				}
+			}
+
+			for c := 0; c < 3; c++ {
+				v := (p & f.masks[c]) >> shifts[c]
+				dst[x*4+c] = uint8(v * 255 / maxs[c])
+			}
+			dst[x*4+3] = 255
+		}
+	}
+
+	return
+}
+
+// an shmSegment is a shared memory segment attached to both sharenix and the
+// X server, which is a lot faster than receiving the image over the socket
+type shmSegment struct {
+	seg  shm.Seg
+	id   int
+	data []byte
+}
+
+// shm segments are reused across captures on the same connection, which
+// matters when recording, until ReleaseShm is called. a nil entry means
+// MIT-SHM is not usable
+var shmSegments = struct {
+	sync.Mutex
+	conns map[*xgb.Conn]*shmSegment
+}{conns: make(map[*xgb.Conn]*shmSegment)}
+
+func (s *shmSegment) free(X *xgb.Conn) {
+	shm.Detach(X, s.seg)
+	shmDetach(s.data)
+}
+
+// ReleaseShm frees the shared memory used by captures on a connection. It
+// should be called once the connection is done capturing, before it is
+// closed
+func ReleaseShm(X *xgb.Conn) {
+	shmSegments.Lock()
+	defer shmSegments.Unlock()
+
+	if s := shmSegments.conns[X]; s != nil {
+		s.free(X)
+	}
+	delete(shmSegments.conns, X)
+}
+
+// getShmSegment returns a shared memory segment of at least size bytes for
+// the given connection
+func getShmSegment(X *xgb.Conn, size int) (s *shmSegment, err error) {
+	shmSegments.Lock()
+	defer shmSegments.Unlock()
+
+	s, known := shmSegments.conns[X]
+	if known && s == nil {
+		err = fmt.Errorf("MIT-SHM is not available")
+		return
+	}
+	if s != nil && len(s.data) >= size {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			DebugPrintln("Not using MIT-SHM:", err)
+			shmSegments.conns[X] = nil
+		}
+	}()
+
+	if s != nil {
+		s.free(X)
+		s = nil
+	} else {
+		if err = shm.Init(X); err != nil {
+			return
+		}
+		if _, err = shm.QueryVersion(X).Reply(); err != nil {
+			return
+		}
+	}
+
+	seg, err := shm.NewSegId(X)
+	if err != nil {
+		return
+	}
+
+	id, data, err := shmCreate(size)
+	if err != nil {
+		return
+	}
+
+	// remote X servers can't attach to our memory. the segment is marked
+	// for deletion as soon as the server is attached, so the system frees
+	// it when both sides detach, even if we crash
+	err = shm.AttachChecked(X, seg, uint32(id), false).Check()
+	shmRemove(id)
+	if err != nil {
+		shmDetach(data)
+		return
+	}
+
+	s = &shmSegment{seg, id, data}
+	shmSegments.conns[X] = s
+	return
+}
+
+// getImageShm captures a rectangle of a drawable through MIT-SHM. the
+// returned data is only valid until the next capture on the same connection
+func getImageShm(X *xgb.Conn, drawable xproto.Drawable, rect image.Rectangle,
+	maxSize int) (data []byte, depth byte, visual xproto.Visualid, err error) {
+
+	s, err := getShmSegment(X, maxSize)
+	if err != nil {
+		return
+	}
+
+	reply, err := shm.GetImage(X, drawable, int16(rect.Min.X),
+		int16(rect.Min.Y), uint16(rect.Dx()), uint16(rect.Dy()), 0xFFFFFFFF,
+		xproto.ImageFormatZPixmap, s.seg, 0).Reply()
+	if err != nil {
+		return
+	}
+
+	data = s.data[:reply.Size]
+	depth = reply.Depth
+	visual = reply.Visual
+	return
+}
+
+// getImage captures a rectangle of a drawable with a plain GetImage request
+func getImage(X *xgb.Conn, drawable xproto.Drawable, rect image.Rectangle) (
+	data []byte, depth byte, visual xproto.Visualid, err error) {
+
+	reply, err := xproto.GetImage(X, xproto.ImageFormatZPixmap, drawable,
+		int16(rect.Min.X), int16(rect.Min.Y),
+		uint16(rect.Dx()), uint16(rect.Dy()), 0xFFFFFFFF).Reply()
+	if err != nil {
+		return
+	}
+
+	data = reply.Data
+	depth = reply.Depth
+	visual = reply.Visual
+	return
+}
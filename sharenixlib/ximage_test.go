@@ -0,0 +1,207 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"fmt"
+	"github.com/BurntSushi/xgb/xproto"
+	"image"
+	"image/color"
+	"math/bits"
+	"testing"
+)
+
+var testFormats = map[string]*pixelFormat{
+	"bgrx":             {32, 32, false, [3]uint32{0xFF0000, 0xFF00, 0xFF}},
+	"xrgb msb first":   {32, 32, true, [3]uint32{0xFF0000, 0xFF00, 0xFF}},
+	"rgbx":             {32, 32, false, [3]uint32{0xFF, 0xFF00, 0xFF0000}},
+	"24 bpp padded":    {24, 32, false, [3]uint32{0xFF0000, 0xFF00, 0xFF}},
+	"rgb565":           {16, 32, false, [3]uint32{0xF800, 0x7E0, 0x1F}},
+	"rgb565 msb first": {16, 16, true, [3]uint32{0xF800, 0x7E0, 0x1F}},
+}
+
+// encodePixels builds ZPixmap data in format f, the inverse of decode
+func encodePixels(f *pixelFormat, img *image.RGBA) []byte {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	stride := f.stride(w)
+	data := make([]byte, stride*h)
+	bytesPerPixel := f.bpp / 8
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := img.RGBAAt(x, y)
+			var p uint32
+			for i, v := range []uint8{c.R, c.G, c.B} {
+				shift := uint32(bits.TrailingZeros32(f.masks[i]))
+				max := f.masks[i] >> shift
+				p |= uint32(v) * max / 255 << shift
+			}
+
+			px := data[y*stride+x*bytesPerPixel:]
+			for i := 0; i < bytesPerPixel; i++ {
+				if f.msbFirst {
+					px[bytesPerPixel-1-i] = uint8(p >> (8 * uint(i)))
+				} else {
+					px[i] = uint8(p >> (8 * uint(i)))
+				}
+			}
+		}
+	}
+
+	return data
+}
+
+// testPattern returns an image with colors that survive the round trip
+// through 5 and 6 bit channels
+func testPattern(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	levels := []uint8{0, 255}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, color.RGBA{
+				levels[x%2], levels[y%2], levels[(x/2+y)%2], 255,
+			})
+		}
+	}
+	return img
+}
+
+func TestPixelFormatDecode(t *testing.T) {
+	// an odd width makes the scanline padding matter
+	src := testPattern(7, 5)
+
+	for name, f := range testFormats {
+		got, err := f.decode(encodePixels(f, src), 7, 5)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+
+	compare:
+		for y := 0; y < 5; y++ {
+			for x := 0; x < 7; x++ {
+				if got.RGBAAt(x, y) != src.RGBAAt(x, y) {
+					t.Errorf("%s: pixel %d,%d is %v, want %v", name, x, y,
+						got.RGBAAt(x, y), src.RGBAAt(x, y))
+					break compare
+				}
+			}
+		}
+	}
+}
+
+func TestPixelFormatStride(t *testing.T) {
+	tests := []struct {
+		f     *pixelFormat
+		width int
+		want  int
+	}{
+		{testFormats["bgrx"], 7, 28},
+		{testFormats["24 bpp padded"], 7, 24},
+		{testFormats["24 bpp padded"], 4, 12},
+		{testFormats["rgb565"], 7, 16},
+		{testFormats["rgb565 msb first"], 7, 14},
+	}
+
+	for _, test := range tests {
+		if got := test.f.stride(test.width); got != test.want {
+			t.Errorf("%d bpp, %d bit pad: stride(%d) = %d, want %d",
+				test.f.bpp, test.f.pad, test.width, got, test.want)
+		}
+	}
+}
+
+func TestPixelFormatShortData(t *testing.T) {
+	f := testFormats["bgrx"]
+	if _, err := f.decode(make([]byte, 10), 2, 2); err == nil {
+		t.Error("decoding too little data didn't fail")
+	}
+}
+
+func BenchmarkPixelFormatDecode(b *testing.B) {
+	src := testPattern(1920, 1080)
+	for _, name := range []string{"bgrx", "24 bpp padded", "rgb565"} {
+		f := testFormats[name]
+		data := encodePixels(f, src)
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				if _, err := f.decode(data, 1920, 1080); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestReleaseShm(t *testing.T) {
+	X := connectXvfb(t, startXvfb(t, 320, 240, 24))
+
+	if _, err := CaptureRect(X, -1, image.Rect(0, 0, 64, 64)); err != nil {
+		t.Fatal(err)
+	}
+
+	shmSegments.Lock()
+	_, known := shmSegments.conns[X]
+	shmSegments.Unlock()
+	if !known {
+		t.Fatal("The capture didn't try MIT-SHM")
+	}
+
+	ReleaseShm(X)
+
+	shmSegments.Lock()
+	_, known = shmSegments.conns[X]
+	shmSegments.Unlock()
+	if known {
+		t.Error("ReleaseShm didn't forget the connection")
+	}
+}
+
+// BenchmarkCaptureRect compares MIT-SHM with plain GetImage captures of a
+// 1920x1080 screen at several depths
+func BenchmarkCaptureRect(b *testing.B) {
+	for _, depth := range []int{16, 24} {
+		X := connectXvfb(b, startXvfb(b, 1920, 1080, depth))
+		screen := xproto.Setup(X).DefaultScreen(X)
+		rect := image.Rect(0, 0, int(screen.WidthInPixels),
+			int(screen.HeightInPixels))
+
+		for _, useShm := range []bool{true, false} {
+			name := fmt.Sprintf("depth %d getimage", depth)
+			if useShm {
+				name = fmt.Sprintf("depth %d shm", depth)
+			}
+
+			b.Run(name, func(b *testing.B) {
+				ReleaseShm(X)
+				defer ReleaseShm(X)
+				if !useShm {
+					// a nil segment disables MIT-SHM on the connection
+					shmSegments.Lock()
+					shmSegments.conns[X] = nil
+					shmSegments.Unlock()
+				}
+
+				for i := 0; i < b.N; i++ {
+					if _, err := CaptureRect(X, -1, rect); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
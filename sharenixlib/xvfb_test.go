@@ -0,0 +1,75 @@
+/*
+   Copyright 2014 Franc[e]sco (lolisamurai@tfwno.gf)
+   This file is part of sharenix.
+   sharenix is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   sharenix is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with sharenix. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package sharenixlib
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/BurntSushi/xgb"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// startXvfb starts a virtual X server with a single screen of the given size
+// and depth and returns its display name. The server is killed when the test
+// ends. Tests are skipped if Xvfb isn't installed
+func startXvfb(tb testing.TB, width, height, depth int) string {
+	tb.Helper()
+
+	if _, err := exec.LookPath("Xvfb"); err != nil {
+		tb.Skip("Xvfb is not installed")
+	}
+
+	// Xvfb picks a free display and writes its number to the pipe
+	r, w, err := os.Pipe()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer r.Close()
+
+	cmd := exec.Command("Xvfb", "-displayfd", "3", "-nolisten", "tcp",
+		"-screen", "0", fmt.Sprintf("%dx%dx%d", width, height, depth))
+	cmd.ExtraFiles = []*os.File{w}
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil {
+		tb.Fatal("Xvfb didn't report its display:", err)
+	}
+	return ":" + strings.TrimSpace(line)
+}
+
+// connectXvfb opens a connection to a display started by startXvfb
+func connectXvfb(tb testing.TB, display string) *xgb.Conn {
+	tb.Helper()
+
+	X, err := xgb.NewConnDisplay(display)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(X.Close)
+	return X
+}